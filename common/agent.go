@@ -0,0 +1,51 @@
+package common
+
+import (
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	agentOnce   sync.Once
+	agentClient agent.ExtendedAgent
+)
+
+// sshAgent connect to local ssh-agent via SSH_AUTH_SOCK, nil if unavailable or has no keys
+func sshAgent() agent.ExtendedAgent {
+	agentOnce.Do(func() {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return
+		}
+		ac := agent.NewClient(conn)
+		if signers, err := ac.Signers(); err != nil || len(signers) == 0 {
+			conn.Close()
+			return
+		}
+		agentClient = ac
+	})
+	return agentClient
+}
+
+// forwardAgent forward local ssh-agent to sess, no-op if agent is unavailable
+func forwardAgent(client *ssh.Client, sess *ssh.Session) error {
+	ac := sshAgent()
+	if ac == nil {
+		return nil
+	}
+	_, err := channelHandler(client, "auth-agent@openssh.com", func() (interface{}, error) {
+		return nil, agent.ForwardToAgent(client, ac)
+	})
+	if err != nil {
+		return err
+	}
+	return agent.RequestAgentForwarding(sess)
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Aggregate stats of hosts whose trimmed output is a single number
+type Aggregate struct {
+	Count   int
+	Sum     float64
+	Min     float64
+	Max     float64
+	Mean    float64
+	MinHost string   // first host having Min
+	MaxHost string   // first host having Max
+	Skipped []string // hosts whose output is not a number
+	Failed  []string // hosts with error, no output counted
+}
+
+// Aggregate parse trimmed output of every host as float and compute sum, min, max and mean
+func (rc *RemoteCommand) Aggregate() Aggregate {
+	var a Aggregate
+	for _, r := range rc.Result() {
+		if r.Error != "" {
+			a.Failed = append(a.Failed, r.Host)
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(r.Stdout), 64)
+		if err != nil {
+			a.Skipped = append(a.Skipped, r.Host)
+			continue
+		}
+		if a.Count == 0 || v < a.Min {
+			a.Min, a.MinHost = v, r.Host
+		}
+		if a.Count == 0 || v > a.Max {
+			a.Max, a.MaxHost = v, r.Host
+		}
+		a.Count++
+		a.Sum += v
+	}
+	if a.Count > 0 {
+		a.Mean = a.Sum / float64(a.Count)
+	}
+	return a
+}
+
+// PrintAggregate print Aggregate to wo, hosts left out are noted on we
+func (rc *RemoteCommand) PrintAggregate(wo io.Writer, we io.Writer) {
+	a := rc.Aggregate()
+	if a.Count == 0 {
+		fmt.Fprintln(wo, "no numeric output")
+	} else {
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+		fmt.Fprintf(wo, "hosts: %d\nsum:   %s\nmean:  %s\nmin:   %s (%s)\nmax:   %s (%s)\n",
+			a.Count, f(a.Sum), f(a.Mean), f(a.Min), a.MinHost, f(a.Max), a.MaxHost)
+	}
+	if len(a.Skipped) > 0 {
+		fmt.Fprintf(we, "skipped %d hosts with non-numeric output: %s\n", len(a.Skipped), strings.Join(a.Skipped, ","))
+	}
+	if len(a.Failed) > 0 {
+		fmt.Fprintf(we, "skipped %d failed hosts: %s\n", len(a.Failed), strings.Join(a.Failed, ","))
+	}
+}
+
+// ValueCounts hosts bucketed by trimmed output, most common value first, ties in host order.
+// hosts with error are left out and returned as failed
+func (rc *RemoteCommand) ValueCounts() (counts []OutputGroup, failed []string) {
+	values := make(map[string]string)
+	var hosts []string
+	for _, r := range rc.Result() {
+		if r.Error != "" {
+			failed = append(failed, r.Host)
+			continue
+		}
+		hosts = append(hosts, r.Host)
+		values[r.Host] = strings.TrimSpace(r.Stdout)
+	}
+	return groupOutputs(hosts, values, nil), failed
+}
+
+// PrintValueCounts print frequency table of trimmed outputs to wo, with the hosts of every value
+// if withHosts is set. failed hosts are noted on we
+func (rc *RemoteCommand) PrintValueCounts(wo io.Writer, we io.Writer, withHosts bool) {
+	counts, failed := rc.ValueCounts()
+	width := 0
+	for _, c := range counts {
+		if n := len(strconv.Itoa(len(c.Hosts))); n > width {
+			width = n
+		}
+	}
+	for _, c := range counts {
+		v := c.Output
+		if v == "" {
+			v = "(empty)"
+		}
+		fmt.Fprintf(wo, "%*d  %s\n", width, len(c.Hosts), v)
+		if withHosts {
+			fmt.Fprintf(wo, "%*s  %s\n", width, "", strings.Join(c.Hosts, ","))
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(we, "skipped %d failed hosts: %s\n", len(failed), strings.Join(failed, ","))
+	}
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshConfig algorithm lists from config, empty lists keep secure defaults of x/crypto/ssh.
+// Unknown names are rejected before dialing
+func sshConfig() (ssh.Config, error) {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	lists := []struct {
+		name       string
+		configured []string
+		known      [][]string
+	}{
+		{"ciphers", C.Server.Ciphers, [][]string{supported.Ciphers, insecure.Ciphers}},
+		{"kex", C.Server.KeyExchanges, [][]string{supported.KeyExchanges, insecure.KeyExchanges}},
+		{"macs", C.Server.MACs, [][]string{supported.MACs, insecure.MACs}},
+	}
+	for _, l := range lists {
+		for _, a := range l.configured {
+			if !containsAny(a, l.known...) {
+				return ssh.Config{}, fmt.Errorf("unsupported %s algorithm: %s", l.name, a)
+			}
+		}
+	}
+	return ssh.Config{
+		Ciphers:      C.Server.Ciphers,
+		KeyExchanges: C.Server.KeyExchanges,
+		MACs:         C.Server.MACs,
+	}, nil
+}
+
+func containsAny(s string, lists ...[]string) bool {
+	for _, list := range lists {
+		for _, v := range list {
+			if v == s {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package common
+
+import "strings"
+
+// stripANSI remove ANSI/VT100 escape sequences like colors and cursor moves. Only 7-bit ESC
+// sequences are matched, so bytes of multibyte UTF-8 are never touched
+func stripANSI(s string) string {
+	if strings.IndexByte(s, 0x1b) < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != 0x1b {
+			b.WriteByte(s[i])
+			continue
+		}
+		i = escapeEnd(s, i)
+	}
+	return b.String()
+}
+
+// escapeEnd get index of last byte of escape sequence starting at s[i], the ESC
+// alone if sequence is broken
+func escapeEnd(s string, i int) int {
+	if i+1 >= len(s) {
+		return i
+	}
+	j := i + 1
+	switch c := s[j]; {
+	case c == '[':
+		// CSI: parameter and intermediate bytes then a final byte
+		for j++; j < len(s); j++ {
+			if s[j] >= 0x40 && s[j] <= 0x7e {
+				return j
+			}
+			if s[j] < 0x20 || s[j] > 0x3f {
+				break
+			}
+		}
+		return j - 1
+	case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+		// OSC and other strings: terminated by BEL or ESC \
+		for j++; j < len(s); j++ {
+			if s[j] == 0x07 {
+				return j
+			}
+			if s[j] == 0x1b && j+1 < len(s) && s[j+1] == '\\' {
+				return j + 1
+			}
+			if s[j] == '\n' {
+				break
+			}
+		}
+		return j - 1
+	case c >= 0x20 && c <= 0x2f:
+		// intermediate bytes like charset selection ESC ( B
+		for ; j < len(s) && s[j] >= 0x20 && s[j] <= 0x2f; j++ {
+		}
+		if j < len(s) && s[j] >= 0x30 && s[j] <= 0x7e {
+			return j
+		}
+		return j - 1
+	case c >= 0x30 && c <= 0x7e:
+		return j
+	}
+	return i
+}
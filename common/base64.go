@@ -0,0 +1,46 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// base64Missing stderr line and exit status of command when base64 is not found at remote host
+const (
+	base64Missing     = "optool: base64 not found at remote host"
+	base64MissingCode = 127
+)
+
+// base64Command wrap cmd to encode its output as base64, keeping exit status of cmd.
+// cmd runs in a subshell so its exit does not skip passing the status on
+func base64Command(cmd string) string {
+	return fmt.Sprintf("command -v base64 >/dev/null 2>&1 || { echo %s >&2; exit %d; }; "+
+		"exec 3>&1; s=$({ { ( %s\n) 3>&- 4>&-; echo $? >&4; } | base64 >&3; } 4>&1); exit \"${s:-1}\"",
+		shellQuote(base64Missing), base64MissingCode, cmd)
+}
+
+// decodeBase64 decode output of base64Command, line breaks are ignored
+func decodeBase64(o string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(o)
+	if err != nil {
+		return "", fmt.Errorf("output is not base64, check base64 at remote host: %v", err)
+	}
+	return string(data), nil
+}
+
+// checkBase64 reject modes whose output can not be base64 decoded as a whole, and output
+// processing which would change binary output
+func (rc *RemoteCommand) checkBase64() error {
+	if !rc.Base64 {
+		return nil
+	}
+	if rc.MergeStderr || rc.RequestPty || rc.PipeMode || rc.Stream != nil {
+		return errors.New("base64 output is not supported with merged stderr, tty, pipe or stream")
+	}
+	// binary output is kept byte for byte, so it can not be masked, filtered or cut
+	if len(rc.Redact) > 0 || rc.OutputFilter != nil || rc.HeadLines > 0 || rc.TailLines > 0 {
+		return errors.New("base64 output can not be redacted, filtered or cut to head or tail lines")
+	}
+	return nil
+}
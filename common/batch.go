@@ -0,0 +1,52 @@
+package common
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// batches hosts split into batches run one after another: single hosts if Serial, BatchSize
+// hosts if set, else one batch of all hosts
+func (rc *RemoteCommand) batches(hosts []string) [][]string {
+	size := rc.BatchSize
+	if rc.Serial {
+		size = 1
+	}
+	if size <= 0 || size >= len(hosts) {
+		return [][]string{hosts}
+	}
+	var batches [][]string
+	for len(hosts) > size {
+		batches = append(batches, hosts[:size])
+		hosts = hosts[size:]
+	}
+	return append(batches, hosts)
+}
+
+// batchGap wait before batch i but the first: SerialDelay and SerialPause if Serial, else
+// BatchDelay and BatchPause. a declined pause stops the run, so batch and the rest are not attempted
+func (rc *RemoteCommand) batchGap(ctx context.Context, i int, batch []string) {
+	if i == 0 || ctx.Err() != nil {
+		return
+	}
+	delay, pause := rc.BatchDelay, rc.BatchPause
+	if rc.Serial {
+		delay, pause = rc.SerialDelay, nil
+		if rc.SerialPause != nil {
+			pause = func(next []string) bool { return rc.SerialPause(next[0]) }
+		}
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+	if pause != nil && !pause(batch) {
+		rc.abort("", "stopped before "+strings.Join(batch, ","))
+	}
+}
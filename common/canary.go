@@ -0,0 +1,104 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CanarySize hosts of a canary like "5" or "10%" out of total hosts, at least 1 and at most total
+func CanarySize(spec string, total int) (int, error) {
+	pct := strings.HasSuffix(spec, "%")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+	if err != nil || v <= 0 || pct && v > 100 {
+		return 0, fmt.Errorf("bad canary %q, want hosts count like 5 or percentage like 10%%", spec)
+	}
+	n := int(v)
+	if pct {
+		n = int(float64(total) * v / 100)
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > total {
+		n = total
+	}
+	return n, nil
+}
+
+// SplitCanary split into command of first n hosts in input order and command of the rest,
+// both with same Cmd and options. rest is nil if no host is left. Templates of rest keep
+// Index of hosts in the whole host list
+func (rc *RemoteCommand) SplitCanary(n int) (canary, rest *RemoteCommand) {
+	hosts := rc.hostList()
+	if n > len(hosts) {
+		n = len(hosts)
+	}
+	canary = rc.withHosts(hosts[:n])
+	canary.indexOffset = rc.indexOffset
+	if n < len(hosts) {
+		rest = rc.withHosts(hosts[n:])
+		rest.indexOffset = rc.indexOffset + n
+	}
+	return canary, rest
+}
+
+// JoinCanary command holding results of canary and rest as one run in host order of both,
+// so outputs, summary, history and exit status cover every host. rest not run may be nil
+func JoinCanary(canary, rest *RemoteCommand) *RemoteCommand {
+	if rest == nil {
+		return canary
+	}
+	n := canary.withHosts(append(canary.hostList(), rest.hostList()...))
+	n.started = canary.started
+	n.elapsed = rest.started.Add(rest.elapsed).Sub(canary.started)
+	for _, rc := range []*RemoteCommand{canary, rest} {
+		rc.lock.Lock()
+		for h, v := range rc.Output {
+			n.Output[h] = v
+		}
+		for h, v := range rc.Stderr {
+			n.Stderr[h] = v
+		}
+		for h, v := range rc.Error {
+			n.Error[h] = v
+		}
+		for h, v := range rc.errs {
+			n.errs[h] = v
+		}
+		for h, v := range rc.ExitCode {
+			n.ExitCode[h] = v
+		}
+		for h, v := range rc.Attempts {
+			n.Attempts[h] = v
+		}
+		for h, v := range rc.Timing {
+			n.Timing[h] = v
+		}
+		for h, v := range rc.Banner {
+			n.Banner[h] = v
+		}
+		if rc.cmds != nil {
+			if n.cmds == nil {
+				n.cmds = make(map[string]string)
+			}
+			for h, v := range rc.cmds {
+				n.cmds[h] = v
+			}
+		}
+		if rc.Steps != nil {
+			if n.Steps == nil {
+				n.Steps = make(map[string][]StepResult)
+			}
+			for h, v := range rc.Steps {
+				n.Steps[h] = v
+			}
+		}
+		if n.Aborted == "" {
+			n.Aborted, n.AbortedBy = rc.Aborted, rc.AbortedBy
+		}
+		n.NotAttempted = append(n.NotAttempted, rc.NotAttempted...)
+		rc.lock.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fileSHA256 hex sha256 of local file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteSHA256 hex sha256 of remote file by sha256sum, or shasum -a 256 where it is missing like BSD/macOS
+func remoteSHA256(client *ssh.Client, path string) (string, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+	p := shellQuote(path)
+	out, err := sess.CombinedOutput("if command -v sha256sum >/dev/null 2>&1; then sha256sum -- " + p +
+		"; else shasum -a 256 -- " + p + "; fi")
+	if err != nil {
+		return "", fmt.Errorf("sha256 of %s: %v %s", path, err, strings.TrimSpace(string(out)))
+	}
+	f := strings.Fields(string(out))
+	if len(f) == 0 {
+		return "", fmt.Errorf("sha256 of %s: no output", path)
+	}
+	return strings.ToLower(f[0]), nil
+}
+
+// verifyChecksum check remote file has sha256 sum
+func verifyChecksum(client *ssh.Client, path, sum string) error {
+	remote, err := remoteSHA256(client, path)
+	if err != nil {
+		return err
+	}
+	if remote != sum {
+		return fmt.Errorf("checksum mismatch: local sha256 %s, remote %s", sum, remote)
+	}
+	return nil
+}
@@ -1,63 +1,314 @@
 package common
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// killGrace wait after SIGTERM before SIGKILL when command timed out
+const killGrace = 5 * time.Second
+
 // RemoteCommand remote command structure
 type RemoteCommand struct {
-	lock     sync.Mutex
-	wg       *sync.WaitGroup
-	Hosts    []string
-	Cmd      string
+	lock      sync.Mutex
+	wg        *sync.WaitGroup
+	pipeReady *sync.WaitGroup  // hosts whose pipes are not set up yet
+	sem       chan struct{}    // limit in-flight executions, nil means unlimited
+	dialTick  <-chan time.Time // gate of new connections by DialRate, nil means unlimited
+	doneCh    chan HostResult  // results of finished hosts passed to OnHostDone
+	jump      *ssh.Client      // connection of jump host, nil if not used
+	Hosts     []string
+	Cmd       string
+	stdin     []byte // fed to command in non-pipe mode, set by RunScript
+	// Commands command of host run instead of Cmd, see NewRemoteCommandPlan
+	Commands map[string]string
+	// Template renders Cmd as text/template per host with CommandVars, like app@{{.Index}}
+	Template bool
+	HostVars map[string]map[string]string // host => variables available as .Vars in template
+	// indexOffset is Index of first host in templates, set on rest hosts by SplitCanary
+	indexOffset int
+	// StripANSI removes escape sequences like colors from output and stderr, so they print
+	// clean and compare equal in grouping and diff
+	StripANSI bool
+	// MergeStderr interleaves stderr into Output in arrival order, Stderr stays empty.
+	// In PipeMode PipeOut of each host is the combined stream and PipeError is not set.
+	// Not supported with gzip
+	MergeStderr bool
+	// Base64 encodes output at remote host and decodes it when stored, so binary output is kept
+	// byte for byte in Output and WriteOutputDir, ansi escapes included. Not supported with
+	// MergeStderr, RequestPty, PipeMode, Stream, Redact, OutputFilter, HeadLines or TailLines
+	Base64 bool
+	// ShowBanner makes PrettyPrint start with login banners of hosts
+	ShowBanner bool
+	// PrintSummary makes PrettyPrint end with a line counting hosts by outcome
+	PrintSummary bool
+	// OnHostDone is called with result of each host once it finished, from a single goroutine
+	// so calls are serialized. A slow callback never blocks hosts, the run waits for it at end
+	OnHostDone func(host string, result HostResult)
+	// ForwardAgent forwards local ssh-agent so commands like git pull can use local keys
+	ForwardAgent bool
+	// ForwardX11 shows X11 windows of commands on local DISPLAY, skipped with a warning
+	// if no local X server is reachable
+	ForwardX11 bool
+	// MaxOutputBytes kill command once its stdout or stderr exceeds this size, output so far is
+	// kept and host fails as truncated. 0 means no limit
+	MaxOutputBytes int64
+	// ContinueOnError keeps running remaining commands of RunCommands after one failed
+	ContinueOnError bool
+	Steps           map[string][]StepResult // results of RunCommands by host and command index
+	stepMarker      string                  // marker of running RunCommands, output is filtered once split
+	// DryRun skips connecting, Output of each host is the command line that would run
+	DryRun   bool
+	cmds     map[string]string // rendered command of hosts
 	PipeMode bool
+	Color    bool // colorize PrettyPrint, hosts in cyan, errors in red and output header in green
+	// ErrorsOnly makes PrettyPrint skip the OUTPUT section, FailedOnly limits it to hosts with
+	// non-zero exit code and DiffersOnly makes PrettyPrintGrouped skip the most common output
+	ErrorsOnly  bool
+	FailedOnly  bool
+	DiffersOnly bool
+	// MarkEmpty makes PrettyPrint show (empty) for hosts listed by EmptyOutputHosts
+	MarkEmpty bool
+	// SortHosts order of hosts in outputs: SortInput, SortLexical or SortNatural
+	SortHosts string
+	// OutputFilter keeps only output lines matching it like grep, FilterInvert keeps the
+	// lines not matching and FilterCount replaces output with the number of kept lines.
+	// Gzip output is filtered once decompressed, stderr is not filtered. With RunCommands the
+	// output of each step in Steps is filtered on its own
+	OutputFilter *regexp.Regexp
+	FilterInvert bool
+	FilterCount  bool
+	// HeadLines and TailLines keep only first and last lines of each output when read,
+	// marking the omitted ones. Steps of RunCommands are not cut. 0 means no limit
+	HeadLines int
+	TailLines int
+
+	// Stream receives output line by line as it arrives instead of buffering into Output/Stderr,
+	// calls are serialized
+	Stream     func(host, line string, isErr bool)
+	streamLock sync.Mutex
+
+	// RequestPty allocate a pty for commands that need a terminal.
+	// pty merges stderr into stdout, so Stderr is usually empty
+	RequestPty bool
+	PtyTerm    string // default term_type of config or xterm-256color
+	PtyRows    int    // default 24
+	PtyCols    int    // default 80
+
+	// KubeContainer container of pods commands run in by RunPods, empty is the default one
+	KubeContainer string
+
+	// WorkDir directory command runs in, hosts fail if it does not exist. WorkDirs sets it by host
+	WorkDir  string
+	WorkDirs map[string]string
+
+	// Env environment variables set by Setenv, sshd may restrict them via AcceptEnv.
+	// With EnvExport rejected variables are exported in command instead of failing the host
+	Env       map[string]string
+	EnvExport bool
+
+	// Sudo run command by sudo as root, SudoPassword is written to stdin and never echoed
+	// since prompt is empty. Empty SudoPassword falls back to the login password asked on terminal.
+	// Without password sudo runs non-interactive and fails instead of hanging
+	Sudo         bool
+	SudoPassword string
+
+	// MaxFailures abort the run once this many hosts failed or exited non-zero, 0 means never
+	MaxFailures int
+	// FailFast abort the run as soon as any host failed or exited non-zero
+	FailFast     bool
+	Aborted      string   // reason the run was aborted
+	AbortedBy    string   // host whose failure triggered the abort
+	NotAttempted []string // hosts skipped because of abort
+	failures     int
+	cancel       context.CancelFunc
+
+	// CommandTimeout kill command of a host running longer than it and fail only that host
+	// with TimeoutError, 0 means no limit. CommandTimeouts sets it by host
+	CommandTimeout  time.Duration
+	CommandTimeouts map[string]time.Duration
+	// Serial run hosts one after another in input order, each finished before the next starts.
+	// SerialDelay waits between hosts and SerialPause, if set, is asked before every host but
+	// the first, false stops the run. With FailFast a failed host stops the rollout
+	Serial      bool
+	SerialDelay time.Duration
+	SerialPause func(next string) bool
+	// BatchSize run hosts in batches of this size one after another, hosts of a batch in
+	// parallel. BatchDelay waits between batches and BatchPause, if set, is asked before every
+	// batch but the first, false stops the run. With FailFast a failure stops the next batches
+	BatchSize  int
+	BatchDelay time.Duration
+	BatchPause func(next []string) bool
+
+	// RunTimeout abort the whole run after it, hosts still running or not started fail with
+	// TimeoutError. 0 means no limit
+	RunTimeout  time.Duration
+	runTimedOut bool
+
+	// VerifyChecksum compare sha256 of files sent by Upload with the local file, a mismatch
+	// is an error of the host
+	VerifyChecksum bool
+	// BackupUpload keep file replaced by Upload as <path>.bak
+	BackupUpload bool
+	// NoReplace fail Upload on hosts where the remote file exists instead of replacing it
+	NoReplace bool
+
+	// Redact masks matches in output, stderr and errors as ***, see RedactPatterns
+	Redact []*regexp.Regexp
+
+	// BandwidthLimit caps bytes per second of Upload and Download summed over all hosts,
+	// HostBandwidthLimit caps each host. 0 means no limit
+	BandwidthLimit     int64
+	HostBandwidthLimit int64
+
+	// Pool reuses connections across runs sharing it, which must be closed by Pool.CloseAll
+	Pool *Pool
+
+	RetryAttempts int           // max connect attempts on connection errors, <=1 means no retry
+	RetryBackoff  time.Duration // wait before first retry, doubled after each attempt
 
 	PipeChan  chan bool
 	PipeIn    map[string]io.WriteCloser
 	PipeOut   map[string]io.Reader
 	PipeError map[string]io.Reader
 
-	Output  map[string]string
-	Error   map[string]string
-	Running map[string]*ssh.Session
+	Output   map[string]string
+	Stderr   map[string]string // remote stderr in buffered mode
+	Error    map[string]string
+	errs     map[string]error // errors behind Error, exposed by Result
+	ExitCode map[string]int   // remote exit status of hosts where command ran
+	Attempts map[string]int   // connect attempts of hosts
+	Timing   map[string]HostTiming
+	Banner   map[string]string       // login banner sent by hosts
+	started  time.Time               // start of last run
+	elapsed  time.Duration           // wall clock time of last run
+	Running  map[string]*ssh.Session // sessions of commands in flight
+}
+
+// NewRemoteCommandArgs prepare a remote execution of name with args quoted by QuoteCommand
+func NewRemoteCommandArgs(hosts []string, name string, args ...string) *RemoteCommand {
+	return NewRemoteCommand(hosts, QuoteCommand(name, args...))
 }
 
 // NewRemoteCommand prepare a remote execution
 func NewRemoteCommand(hosts []string, cmd string) *RemoteCommand {
 	if C.Gzip {
-		cmd = cmd + " | /usr/bin/gzip -f"
+		cmd = gzipCommand(cmd)
 	}
 	return &RemoteCommand{
 		lock:      sync.Mutex{},
 		wg:        &sync.WaitGroup{},
+		pipeReady: &sync.WaitGroup{},
 		Hosts:     hosts,
 		Cmd:       cmd,
 		Output:    make(map[string]string),
+		Stderr:    make(map[string]string),
 		Error:     make(map[string]string),
+		errs:      make(map[string]error),
+		ExitCode:  make(map[string]int),
+		Attempts:  make(map[string]int),
+		Timing:    make(map[string]HostTiming),
+		Banner:    make(map[string]string),
 		Running:   make(map[string]*ssh.Session),
 		PipeIn:    make(map[string]io.WriteCloser),
 		PipeOut:   make(map[string]io.Reader),
 		PipeError: make(map[string]io.Reader),
 		PipeChan:  make(chan bool),
+
+		RetryBackoff: time.Second,
 	}
 }
 
-// Start run remote command
-func (rc *RemoteCommand) Start() (err error) {
+// Start run remote command, get results of all hosts once they are done
+func (rc *RemoteCommand) Start() ([]HostResult, error) {
+	return rc.StartContext(context.Background())
+}
+
+// StartContext run remote command, canceling ctx aborts dialing and running sessions
+func (rc *RemoteCommand) StartContext(ctx context.Context) ([]HostResult, error) {
+	if err := checkGzipLevel(); err != nil {
+		return nil, err
+	}
+	if rc.MergeStderr && C.Gzip {
+		return nil, errors.New("merging stderr is not supported with gzip")
+	}
+	if err := rc.checkBase64(); err != nil {
+		return nil, err
+	}
+	if err := rc.renderCommands(); err != nil {
+		return nil, err
+	}
+	if rc.DryRun {
+		return rc.dryRun()
+	}
+	if err := rc.run(ctx, rc.runCommand); err != nil {
+		return nil, err
+	}
+	return rc.Result(), nil
+}
+
+// dryRun fill Output of every host with command line that would run, env is shown as exports
+func (rc *RemoteCommand) dryRun() ([]HostResult, error) {
+	prefix := ""
+	if len(rc.Env) > 0 {
+		var err error
+		if prefix, err = exportPrefix(rc.Env); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range rc.hostList() {
+		cmd := prefix + rc.command(h)
+		if rc.Sudo {
+			cmd = sudoCommand(cmd, rc.SudoPassword != "", !rc.PipeMode && rc.stdin == nil)
+		}
+		rc.lock.Lock()
+		rc.Output[h] = cmd
+		rc.lock.Unlock()
+	}
+	return rc.Result(), nil
+}
+
+// run connect to every host and run action on it
+func (rc *RemoteCommand) run(ctx context.Context, action hostAction) (err error) {
+	if (rc.Serial || rc.BatchSize > 0) && rc.PipeMode {
+		return errors.New("serial and batches are not supported in pipe mode")
+	}
+	ctx, rc.cancel = context.WithCancel(ctx)
+	defer rc.cancel()
+	defer rc.startRunTimeout()()
+	start := time.Now()
+	rc.started = start
+	metricRuns.Inc()
+	defer func() {
+		rc.elapsed = time.Since(start)
+		metricRunDuration.Observe(rc.elapsed.Seconds())
+	}()
+	hostKeyCallback, err := HostKeyCallback()
+	if err != nil {
+		return err
+	}
+	algorithms, err := sshConfig()
+	if err != nil {
+		return err
+	}
 	cfg := &ssh.ClientConfig{
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Second * 10,
+		Config:          algorithms,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         connectTimeout(),
 	}
 	if C.Auth.User != "" {
 		cfg.User = C.Auth.User
@@ -66,118 +317,1069 @@ func (rc *RemoteCommand) Start() (err error) {
 			return err
 		}
 	}
-	for _, host := range rc.Hosts {
-		rc.wg.Add(1)
-		//L.Info("host=", host)
-		go rc.execute(host, cfg)
+	if C.Server.SSHConfig != "" {
+		if err = ParseSSHConfig(C.Server.SSHConfig); err != nil {
+			return err
+		}
+	}
+	if C.Server.JumpHost != "" {
+		if err = rc.dialJump(ctx, cfg); err != nil {
+			return fmt.Errorf("jump host %s: %v", C.Server.JumpHost, err)
+		}
+		defer rc.releaseJump()
 	}
-	if rc.PipeMode {
-		rc.PipeChan <- true
+	if C.Server.DialRate > 0 {
+		// huge rates would round to 0 on which NewTicker panics
+		interval := time.Duration(float64(time.Second) / C.Server.DialRate)
+		if interval < 1 {
+			interval = 1
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		rc.dialTick = ticker.C
+	} else {
+		rc.dialTick = nil
+	}
+	// pipe consumers wait for every host, limiting would block forever
+	if C.Server.MaxParallel > 0 && !rc.PipeMode {
+		rc.sem = make(chan struct{}, C.Server.MaxParallel)
+	}
+	if rc.OnHostDone != nil {
+		rc.doneCh = make(chan HostResult, len(rc.Hosts))
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			for r := range rc.doneCh {
+				rc.OnHostDone(r.Host, r)
+			}
+		}()
+		defer func() {
+			close(rc.doneCh)
+			<-drained
+			rc.doneCh = nil
+		}()
+	}
+	for i, batch := range rc.batches(rc.Hosts) {
+		rc.batchGap(ctx, i, batch)
+		for _, host := range batch {
+			rc.wg.Add(1)
+			if rc.PipeMode {
+				rc.pipeReady.Add(1)
+			}
+			L.Debugf("start host=%s", host)
+			go rc.execute(ctx, host, cfg, action)
+		}
+		if rc.PipeMode {
+			// pipes of every reachable host are ready to read/write
+			rc.pipeReady.Wait()
+			rc.PipeChan <- true
+		}
+		rc.wg.Wait()
 	}
-	rc.wg.Wait()
 	return nil
 }
 
-// execute execute command at host
-func (rc *RemoteCommand) execute(host string, cfg *ssh.ClientConfig) {
+// hostAction work done on a connected host, ready must be called once pipes are set up in PipeMode
+type hostAction func(ctx context.Context, ohost string, client *ssh.Client, timing *HostTiming, ready func())
+
+// execute connect to host and run action
+func (rc *RemoteCommand) execute(ctx context.Context, host string, cfg *ssh.ClientConfig, action hostAction) {
+	defer rc.wg.Done()
+	ready := func() {}
+	if rc.PipeMode {
+		// release pipe waiting once pipes are set up or host failed
+		readyOnce := sync.Once{}
+		ready = func() { readyOnce.Do(rc.pipeReady.Done) }
+		defer ready()
+	}
 	ohost := host
-	if strings.Index(host, ":") < 0 {
-		host = host + ":" + strconv.Itoa(C.Server.DefaultPort)
+	defer rc.notifyDone(ohost)
+	defer rc.hostDone(ohost)
+	if rc.sem != nil {
+		select {
+		case rc.sem <- struct{}{}:
+			defer func() { <-rc.sem }()
+		case <-ctx.Done():
+			rc.notAttempted(ctx, ohost)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		rc.notAttempted(ctx, ohost)
+		return
 	}
-	client, err := ssh.Dial("tcp", host, cfg)
+	metricHosts.Inc()
+	metricHostsRunning.Inc()
+	defer rc.observeHost(ohost)
+	var timing HostTiming
+	defer func() {
+		rc.lock.Lock()
+		rc.Timing[ohost] = timing
+		rc.lock.Unlock()
+	}()
+	user, host := splitUser(host)
+	addr, cfg, err := applySSHConfig(host, cfg)
 	if err != nil {
+		rc.setError(ohost, &AuthError{Host: ohost, Err: err})
+		return
+	}
+	// per host copy to record banner of host
+	bcfg := *cfg
+	bcfg.BannerCallback = func(message string) error {
 		rc.lock.Lock()
-		rc.Error[ohost] = err.Error()
+		rc.Banner[ohost] = message
 		rc.lock.Unlock()
-		rc.wg.Done()
+		return nil
+	}
+	cfg = &bcfg
+	if user != "" {
+		ucfg := *cfg
+		ucfg.User = user
+		if ucfg.Auth == nil {
+			if ucfg.Auth, err = GetAuth(); err != nil {
+				rc.setError(ohost, &AuthError{Host: ohost, Err: err})
+				return
+			}
+		}
+		cfg = &ucfg
+	}
+	ts := time.Now()
+	key := cfg.User + "@" + addr
+	var client *ssh.Client
+	if rc.Pool != nil {
+		var banner string
+		if client, banner = rc.Pool.acquire(key); client != nil && banner != "" {
+			rc.lock.Lock()
+			rc.Banner[ohost] = banner
+			rc.lock.Unlock()
+		}
+	}
+	if client == nil {
+		client, err = rc.dialRetry(ctx, ohost, addr, cfg)
+		if err == nil && rc.Pool != nil {
+			rc.lock.Lock()
+			banner := rc.Banner[ohost]
+			rc.lock.Unlock()
+			rc.Pool.add(key, client, banner)
+		}
+	}
+	timing.Dial = time.Since(ts)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = rc.canceled(ctx, ohost)
+		} else {
+			err = connectError(ohost, err)
+		}
+		rc.setError(ohost, err)
 		return
 	}
-	defer client.Close()
+	lost := false
+	defer func() {
+		if rc.Pool != nil {
+			rc.Pool.release(key, client, lost)
+		} else {
+			client.Close()
+		}
+	}()
+	stopKeepAlive := keepAlive(client)
+	action(ctx, ohost, client, &timing, ready)
+	// a host whose command exited before cancel keeps its result
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		rc.lock.Lock()
+		code, exited := rc.ExitCode[ohost]
+		rc.lock.Unlock()
+		// killed by signal of cancel has no exit status
+		interrupted = !exited || code < 0
+	}
+	if lost = stopKeepAlive(); lost {
+		rc.setError(ohost, &DialError{Host: ohost, Err: fmt.Errorf("connection lost: %d keepalives got no reply", keepAliveMax())})
+	} else if interrupted {
+		rc.setError(ohost, rc.canceled(ctx, ohost))
+	}
+}
+
+// runCommand run rc.Cmd in a new session of client
+func (rc *RemoteCommand) runCommand(ctx context.Context, ohost string, client *ssh.Client, timing *HostTiming, ready func()) {
+	ts := time.Now()
 	sess, err := client.NewSession()
+	timing.Session = time.Since(ts)
 	if err != nil {
+		rc.setError(ohost, execError(ohost, err))
+		return
+	}
+	defer sess.Close()
+	rc.lock.Lock()
+	rc.Running[ohost] = sess
+	rc.lock.Unlock()
+	defer func() {
 		rc.lock.Lock()
-		rc.Error[ohost] = err.Error()
+		delete(rc.Running, ohost)
 		rc.lock.Unlock()
+	}()
+	// stop remote command on cancel or timeout
+	done := make(chan struct{})
+	defer close(done)
+	var timeout <-chan time.Time
+	limit := rc.commandTimeout(ohost)
+	if limit > 0 {
+		timer := time.NewTimer(limit)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var timedOut int32
+	defer func() {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			rc.setError(ohost, execError(ohost, &TimeoutError{Host: ohost, Timeout: limit}))
+		}
+	}()
+	// kill command once output exceeds MaxOutputBytes
+	var truncated int32
+	truncate := func() {
+		if atomic.CompareAndSwapInt32(&truncated, 0, 1) {
+			sess.Signal(ssh.SIGKILL)
+			sess.Close()
+		}
+	}
+	defer func() {
+		if atomic.LoadInt32(&truncated) == 1 {
+			rc.setError(ohost, execError(ohost, fmt.Errorf("output truncated at %d bytes", rc.MaxOutputBytes)))
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Signal(ssh.SIGTERM)
+			sess.Close()
+		case <-timeout:
+			atomic.StoreInt32(&timedOut, 1)
+			sess.Signal(ssh.SIGTERM)
+			select {
+			case <-time.After(killGrace):
+				sess.Signal(ssh.SIGKILL)
+			case <-done:
+			}
+			sess.Close()
+		case <-done:
+		}
+	}()
+	if rc.ForwardAgent {
+		if err = forwardAgent(client, sess); err != nil {
+			rc.setError(ohost, execError(ohost, fmt.Errorf("agent forwarding: %v", err)))
+			return
+		}
+	}
+	if rc.ForwardX11 {
+		if err = forwardX11(client, sess); err != nil {
+			rc.setError(ohost, execError(ohost, fmt.Errorf("x11 forwarding: %v", err)))
+			return
+		}
+	}
+	if rc.RequestPty {
+		if err = rc.requestPty(sess); err != nil {
+			rc.setError(ohost, execError(ohost, err))
+			return
+		}
+	}
+	cmd, err := rc.setEnv(sess, rc.command(ohost))
+	if err != nil {
+		rc.setError(ohost, execError(ohost, err))
 		return
 	}
-	defer sess.Close()
-	var o []byte
-	var e error
-	// @todo std pipes
+	var stdin []io.Reader
+	var sudoPassword string
+	if rc.Sudo {
+		sudoPassword = rc.sudoPassword(client.User())
+		// piped stdin belongs to command, otherwise command must not read the password
+		cmd = sudoCommand(cmd, sudoPassword != "", !rc.PipeMode && rc.stdin == nil)
+		if sudoPassword != "" && !rc.PipeMode {
+			stdin = append(stdin, strings.NewReader(sudoPassword+"\n"))
+		}
+	}
+	if rc.stdin != nil && !rc.PipeMode {
+		stdin = append(stdin, bytes.NewReader(rc.stdin))
+	}
+	if len(stdin) > 0 {
+		sess.Stdin = io.MultiReader(stdin...)
+	}
+	ts = time.Now()
+	defer func() { timing.Command = time.Since(ts) }()
 	if rc.PipeMode {
-		rc.Running[ohost] = sess
-		//rc.PipeIn[ohost], e = sess.StdinPipe()
-		rc.PipeOut[ohost], e = sess.StdoutPipe()
-		rc.PipeError[ohost], e = sess.StderrPipe()
-		e = sess.Start(rc.Cmd)
-		e = sess.Wait()
-		rc.wg.Done()
+		rc.executePipe(ohost, sess, cmd, sudoPassword, ready)
+		return
+	}
+	if rc.Stream != nil {
+		rc.setExit(ohost, rc.executeStream(ohost, sess, cmd, truncate))
+		return
+	}
+	stdout, stderr, merged := &bytes.Buffer{}, &bytes.Buffer{}, &lockedBuffer{}
+	var ow, ew io.Writer = stdout, stderr
+	if rc.MergeStderr {
+		ow, ew = merged, merged
+	}
+	if rc.MaxOutputBytes > 0 {
+		ow = &limitWriter{w: ow, left: rc.MaxOutputBytes, exceeded: truncate}
+		if rc.MergeStderr {
+			ew = ow
+		} else {
+			ew = &limitWriter{w: ew, left: rc.MaxOutputBytes, exceeded: truncate}
+		}
+	}
+	sess.Stdout, sess.Stderr = ow, ew
+	e := sess.Run(cmd)
+	o := stdout.Bytes()
+	if rc.MergeStderr {
+		o = merged.Bytes()
+	}
+	L.Debugf("RemoteCommand: [%s] cmd=%s, output=%d bytes, error=%v", ohost, cmd, len(o), e)
+	rc.storeOutput(ohost, string(o), stderr.String())
+	errText := stderr.String()
+	if rc.MergeStderr {
+		errText = string(merged.Bytes())
+	}
+	code, _ := exitCode(e)
+	if C.Gzip && code == gzipMissingCode && strings.Contains(errText, gzipMissing) ||
+		rc.Base64 && code == base64MissingCode && strings.Contains(errText, base64Missing) ||
+		code == workDirMissingCode && strings.Contains(errText, workDirMissing) {
+		e = errors.New(strings.TrimSpace(errText))
+	}
+	rc.setExit(ohost, e)
+}
+
+// storeOutput save buffered output and stderr of host, stripped and filtered unless gzip
+// output which is done when decoded, or base64 output which is decoded and kept as is
+func (rc *RemoteCommand) storeOutput(ohost, out, serr string) {
+	plain := !C.Gzip && !rc.Base64
+	if rc.StripANSI {
+		if plain {
+			out = stripANSI(out)
+		}
+		serr = stripANSI(serr)
+	}
+	serr = rc.redact(serr)
+	if plain {
+		out = rc.redact(out)
+		if rc.stepMarker == "" {
+			out = rc.filterOutput(out)
+		}
+	}
+	var decodeErr error
+	if rc.Base64 {
+		// undecodable output is kept as received
+		if d, err := decodeBase64(out); err != nil {
+			decodeErr = err
+		} else {
+			out = d
+		}
+	}
+	rc.lock.Lock()
+	if decodeErr != nil {
+		rc.recordError(ohost, execError(ohost, decodeErr))
+	}
+	rc.Output[ohost] = out
+	rc.Stderr[ohost] = serr
+	rc.lock.Unlock()
+}
+
+// setExit record error and exit status of command
+func (rc *RemoteCommand) setExit(ohost string, e error) {
+	rc.lock.Lock()
+	if e != nil {
+		rc.recordError(ohost, execError(ohost, e))
+	}
+	if code, ok := exitCode(e); ok {
+		rc.ExitCode[ohost] = code
+	}
+	rc.lock.Unlock()
+}
+
+// setEnv set Env on session, get command with rejected variables exported if EnvExport is set
+func (rc *RemoteCommand) setEnv(sess *ssh.Session, cmd string) (string, error) {
+	rejected := make(map[string]string)
+	for k, v := range rc.Env {
+		if !envNamePattern.MatchString(k) {
+			return "", fmt.Errorf("invalid env name: %q", k)
+		}
+		if err := sess.Setenv(k, v); err != nil {
+			if !rc.EnvExport {
+				return "", fmt.Errorf("setenv %s: %v, check AcceptEnv of sshd", k, err)
+			}
+			rejected[k] = v
+		}
+	}
+	if len(rejected) == 0 {
+		return cmd, nil
+	}
+	prefix, err := exportPrefix(rejected)
+	if err != nil {
+		return "", err
+	}
+	return prefix + cmd, nil
+}
+
+// commandTimeout get command timeout of host, CommandTimeouts overrides CommandTimeout
+func (rc *RemoteCommand) commandTimeout(host string) time.Duration {
+	if d, ok := rc.CommandTimeouts[host]; ok {
+		return d
+	}
+	return rc.CommandTimeout
+}
+
+// requestPty request pty with configured terminal type and size
+func (rc *RemoteCommand) requestPty(sess *ssh.Session) error {
+	term, rows, cols := rc.PtyTerm, rc.PtyRows, rc.PtyCols
+	if term == "" {
+		term = termType(DefaultTermType)
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+	if cols <= 0 {
+		cols = 80
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0, // do not echo input such as sudo password
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	setLocale(sess)
+	return sess.RequestPty(term, rows, cols, modes)
+}
+
+// executeStream run command and pass output lines to Stream
+func (rc *RemoteCommand) executeStream(ohost string, sess *ssh.Session, cmd string, truncate func()) error {
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := sess.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err = sess.Start(cmd); err != nil {
+		return err
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r := stdout
+		if C.Gzip {
+			gr, err := gzipReader(stdout)
+			if err != nil {
+				rc.streamLine(ohost, err.Error(), true)
+				// drain or remote command blocks
+				io.Copy(ioutil.Discard, stdout)
+				return
+			}
+			defer gr.Close()
+			r = gr
+		}
+		if rc.MaxOutputBytes > 0 {
+			r = &limitReader{r: r, left: rc.MaxOutputBytes, exceeded: truncate}
+		}
+		rc.streamLines(ohost, r, false)
+		io.Copy(ioutil.Discard, stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		var r io.Reader = stderr
+		if rc.MaxOutputBytes > 0 {
+			r = &limitReader{r: r, left: rc.MaxOutputBytes, exceeded: truncate}
+		}
+		rc.streamLines(ohost, r, !rc.MergeStderr)
+		io.Copy(ioutil.Discard, stderr)
+	}()
+	wg.Wait()
+	return sess.Wait()
+}
+
+// streamLines read r line by line, the last line may have no line break
+func (rc *RemoteCommand) streamLines(ohost string, r io.Reader, isErr bool) {
+	br := bufio.NewReader(r)
+	count := 0
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			line = strings.TrimRight(line, "\r\n")
+			if rc.StripANSI {
+				line = stripANSI(line)
+			}
+			line = rc.redact(line)
+			if isErr || rc.keepLine(line) {
+				count++
+				if isErr || !rc.FilterCount {
+					rc.streamLine(ohost, line, isErr)
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if !isErr && rc.OutputFilter != nil && rc.FilterCount {
+		rc.streamLine(ohost, strconv.Itoa(count), false)
+	}
+}
+
+func (rc *RemoteCommand) streamLine(ohost, line string, isErr bool) {
+	rc.streamLock.Lock()
+	rc.Stream(ohost, line, isErr)
+	rc.streamLock.Unlock()
+}
+
+// setError record error of host
+func (rc *RemoteCommand) setError(host string, err error) {
+	rc.lock.Lock()
+	rc.recordError(host, err)
+	rc.lock.Unlock()
+}
+
+// recordError save error of host, lock must be held
+func (rc *RemoteCommand) recordError(host string, err error) {
+	rc.Error[host] = rc.redact(err.Error())
+	rc.errs[host] = err
+}
+
+// canceled error for host not finished before ctx is done
+func (rc *RemoteCommand) canceled(ctx context.Context, host string) error {
+	rc.lock.Lock()
+	reason, timedOut := rc.Aborted, rc.runTimedOut
+	rc.lock.Unlock()
+	if timedOut {
+		return &TimeoutError{Host: host, Timeout: rc.RunTimeout, Run: true}
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s", errCanceled, reason)
+	}
+	return fmt.Errorf("%w: %v", errCanceled, ctx.Err())
+}
+
+// abort cancel pending and running hosts, the first reason is kept
+func (rc *RemoteCommand) abort(host, reason string) {
+	rc.lock.Lock()
+	if rc.Aborted == "" {
+		rc.Aborted = reason
+		rc.AbortedBy = host
+	}
+	rc.lock.Unlock()
+	rc.cancel()
+}
+
+// notifyDone pass result of finished host to OnHostDone
+func (rc *RemoteCommand) notifyDone(ohost string) {
+	if rc.doneCh == nil {
+		return
+	}
+	rc.lock.Lock()
+	r := rc.result(ohost)
+	rc.lock.Unlock()
+	rc.doneCh <- r
+}
+
+// hostDone check failure threshold after a host finished
+func (rc *RemoteCommand) hostDone(ohost string) {
+	if rc.MaxFailures <= 0 && !rc.FailFast {
 		return
 	}
-	o, e = sess.Output(rc.Cmd)
-	//L.Debugf("RemoteCommand: [%s] cmd=%s, output=%s, error=%s\n", ohost, rc.Cmd, string(o), e)
 	rc.lock.Lock()
-	rc.Output[ohost] = string(o)
+	_, failed := rc.Error[ohost]
+	if code, ok := rc.ExitCode[ohost]; ok && code != 0 {
+		failed = true
+	}
+	if failed {
+		rc.failures++
+	}
+	failures := rc.failures
+	rc.lock.Unlock()
+	if !failed {
+		return
+	}
+	if rc.FailFast {
+		rc.abort(ohost, fmt.Sprintf("host %s failed, fail fast", ohost))
+	} else if failures >= rc.MaxFailures {
+		rc.abort(ohost, fmt.Sprintf("%d hosts failed, reached max failures %d", failures, rc.MaxFailures))
+	}
+}
+
+// notAttempted record host skipped before dialing
+func (rc *RemoteCommand) notAttempted(ctx context.Context, ohost string) {
+	rc.lock.Lock()
+	rc.NotAttempted = append(rc.NotAttempted, ohost)
+	rc.lock.Unlock()
+	rc.setError(ohost, rc.canceled(ctx, ohost))
+}
+
+// startRunTimeout abort run once RunTimeout expires, stop must be called when run is done
+func (rc *RemoteCommand) startRunTimeout() (stop func()) {
+	rc.runTimedOut = false
+	if rc.RunTimeout <= 0 {
+		return func() {}
+	}
+	t := time.AfterFunc(rc.RunTimeout, func() {
+		rc.lock.Lock()
+		rc.runTimedOut = rc.Aborted == ""
+		rc.lock.Unlock()
+		rc.abort("", fmt.Sprintf("run timed out after %s", rc.RunTimeout))
+	})
+	return func() { t.Stop() }
+}
+
+// PrintAborted print abort reason and hosts never attempted if run was aborted
+func (rc *RemoteCommand) PrintAborted(w io.Writer) {
+	if rc.Aborted == "" {
+		return
+	}
+	fmt.Fprintln(w, "Run aborted:", rc.Aborted)
+	if rc.AbortedBy != "" {
+		fmt.Fprintln(w, "Triggered by:", rc.AbortedBy)
+	}
+	skipped := make(map[string]bool, len(rc.NotAttempted))
+	for _, h := range rc.NotAttempted {
+		skipped[h] = true
+	}
+	var hosts []string
+	for _, h := range rc.hostList() {
+		if skipped[h] {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) > 0 {
+		fmt.Fprintf(w, "%d hosts not attempted: %s\n", len(hosts), strings.Join(hosts, ","))
+	}
+}
+
+// dialRetry dial with retry policy on connection level errors
+func (rc *RemoteCommand) dialRetry(ctx context.Context, ohost, addr string, cfg *ssh.ClientConfig) (client *ssh.Client, err error) {
+	attempts := 0
+	for {
+		attempts++
+		if rc.dialTick != nil {
+			select {
+			case <-rc.dialTick:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		client, err = dial(ctx, rc.jump, addr, cfg)
+		var re *retryableError
+		if err == nil || !errors.As(err, &re) || attempts >= rc.RetryAttempts || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-time.After(rc.RetryBackoff << uint(attempts-1)):
+		case <-ctx.Done():
+		}
+	}
+	rc.lock.Lock()
+	rc.Attempts[ohost] = attempts
+	rc.lock.Unlock()
+	return
+}
+
+// retryableError connection level error, dial failure or connection lost in handshake
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+func (e *retryableError) Unwrap() error { return e.err }
+
+// ioConn record read/write error of a connection
+type ioConn struct {
+	net.Conn
+	lock sync.Mutex
+	err  error
+}
+
+func (c *ioConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.setErr(err)
+	return n, err
+}
+
+func (c *ioConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.setErr(err)
+	return n, err
+}
+
+func (c *ioConn) setErr(err error) {
+	c.lock.Lock()
+	// closed by ourselves or ssh on handshake failure
+	if err != nil && c.err == nil && !errors.Is(err, net.ErrClosed) {
+		c.err = err
+	}
+	c.lock.Unlock()
+}
+
+func (c *ioConn) ioErr() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// splitUser split user@host into user and host, user is empty if not given
+func splitUser(host string) (string, string) {
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return "", host
+}
+
+// hostAddr append default port if host has no port, IPv6 literal like fe80::1 or [::1] becomes [addr]:port
+func hostAddr(host string) string {
+	port := strconv.Itoa(C.Server.DefaultPort)
+	if strings.HasPrefix(host, "[") {
+		if strings.HasSuffix(host, "]") {
+			return host + ":" + port
+		}
+		return host
+	}
+	// bare IPv6 can not carry a port
+	if strings.Count(host, ":") > 1 {
+		return "[" + host + "]:" + port
+	}
+	if strings.Contains(host, ":") {
+		return host
+	}
+	return host + ":" + port
+}
+
+// dialJump connect rc.jump to jump host, reusing connection of Pool if any
+func (rc *RemoteCommand) dialJump(ctx context.Context, cfg *ssh.ClientConfig) (err error) {
+	if rc.Pool != nil {
+		if rc.jump, _ = rc.Pool.acquire(jumpKey()); rc.jump != nil {
+			return nil
+		}
+	}
+	if rc.jump, err = dialJumpHost(ctx, cfg); err == nil && rc.Pool != nil {
+		rc.Pool.add(jumpKey(), rc.jump, "")
+	}
+	return err
+}
+
+// releaseJump close jump host connection of run, or give it back to Pool
+func (rc *RemoteCommand) releaseJump() {
+	if rc.Pool != nil {
+		rc.Pool.release(jumpKey(), rc.jump, false)
+	} else {
+		rc.jump.Close()
+	}
+	rc.jump = nil
+}
+
+// jumpKey Pool key of jump host connection
+func jumpKey() string {
+	return "jump " + C.Server.JumpHost
+}
+
+// dialJumpHost connect to jump host with its own auth if configured
+func dialJumpHost(ctx context.Context, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	jcfg := *cfg
+	if C.Server.JumpAuth.User != "" {
+		jcfg.User = C.Server.JumpAuth.User
+		auth, err := authMethods(&C.Server.JumpAuth)
+		if err != nil {
+			return nil, err
+		}
+		jcfg.Auth = auth
+	}
+	return dial(ctx, nil, hostAddr(C.Server.JumpHost), &jcfg)
+}
+
+// dial connect to ssh server directly or through jump host via,
+// closing connection if ctx is done during handshake
+func dial(ctx context.Context, via *ssh.Client, addr string, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	var nc net.Conn
+	var err error
+	if via != nil {
+		nc, err = via.DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = dialTCP(ctx, addr, cfg.Timeout)
+	}
+	if err != nil {
+		return nil, &retryableError{err}
+	}
+	conn := &ioConn{Conn: nc}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	if cfg.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		ioErr := conn.ioErr()
+		conn.Close()
+		// auth or host key failures are not worth retrying
+		if ioErr != nil {
+			return nil, &retryableError{err}
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// exitCode get remote exit status from session error, false if command did not exit normally
+func exitCode(e error) (int, bool) {
+	if e == nil {
+		return 0, true
+	}
+	var ee *ssh.ExitError
+	if errors.As(e, &ee) {
+		return ee.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// FailedHosts hosts whose command exited with non-zero status, in input order
+func (rc *RemoteCommand) FailedHosts() []string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	var failed []string
+	for _, h := range rc.hostList() {
+		if code, ok := rc.ExitCode[h]; ok && code != 0 {
+			failed = append(failed, h)
+		}
+	}
+	return failed
+}
+
+// EmptyOutputHosts hosts without error whose decompressed output is empty or only blanks, in input order
+func (rc *RemoteCommand) EmptyOutputHosts() []string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	var empty []string
+	for _, h := range rc.hostList() {
+		if rc.emptyOutput(h) {
+			empty = append(empty, h)
+		}
+	}
+	return empty
+}
+
+// emptyOutput whether host finished without error and printed nothing, lock must be held
+func (rc *RemoteCommand) emptyOutput(h string) bool {
+	o, ok := rc.Output[h]
+	if _, failed := rc.Error[h]; !ok || failed {
+		return false
+	}
+	o, err := rc.decode(o)
+	return err == nil && strings.TrimSpace(o) == ""
+}
+
+// executePipe start command with std pipes exported, wait until it exits
+func (rc *RemoteCommand) executePipe(ohost string, sess *ssh.Session, cmd, sudoPassword string, ready func()) {
+	in, e := sess.StdinPipe()
+	var out, stderr io.Reader
+	var combined *io.PipeWriter
+	if e == nil && rc.MergeStderr {
+		// pipe writes are serialized, so both streams keep arrival order
+		out, combined = io.Pipe()
+		sess.Stdout, sess.Stderr = combined, combined
+	} else if e == nil {
+		out, e = sess.StdoutPipe()
+		if e == nil {
+			stderr, e = sess.StderrPipe()
+		}
+	}
+	if e == nil {
+		e = sess.Start(cmd)
+	}
+	if e == nil && sudoPassword != "" {
+		e = writeFull(in, []byte(sudoPassword+"\n"))
+	}
+	rc.lock.Lock()
 	if e != nil {
-		rc.Error[ohost] = e.Error()
+		rc.recordError(ohost, execError(ohost, e))
+	} else {
+		rc.PipeIn[ohost] = in
+		rc.PipeOut[ohost] = out
+		if stderr != nil {
+			rc.PipeError[ohost] = stderr
+		}
 	}
 	rc.lock.Unlock()
-	rc.wg.Done()
+	ready()
+	if e != nil {
+		if combined != nil {
+			combined.CloseWithError(e)
+		}
+		return
+	}
+	e = sess.Wait()
+	if combined != nil {
+		// readers see EOF once the command exited and output is drained
+		combined.Close()
+	}
+	rc.setExit(ohost, e)
+}
+
+// WriteStdin write data to stdin of every piped host then close them, remote commands see EOF.
+// Hosts failed to receive data are recorded in Error
+func (rc *RemoteCommand) WriteStdin(data []byte) error {
+	rc.lock.Lock()
+	pipes := make(map[string]io.WriteCloser, len(rc.PipeIn))
+	for h, w := range rc.PipeIn {
+		pipes[h] = w
+		delete(rc.PipeIn, h)
+	}
+	rc.lock.Unlock()
+	var failed []string
+	wg := sync.WaitGroup{}
+	for h, w := range pipes {
+		wg.Add(1)
+		go func(h string, w io.WriteCloser) {
+			defer wg.Done()
+			err := writeFull(w, data)
+			if cerr := w.Close(); err == nil && cerr != io.EOF {
+				err = cerr
+			}
+			if err == nil {
+				return
+			}
+			rc.lock.Lock()
+			failed = append(failed, h)
+			if _, ok := rc.Error[h]; !ok {
+				rc.recordError(h, fmt.Errorf("write stdin: %v", err))
+			}
+			rc.lock.Unlock()
+		}(h, w)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf("write stdin failed on %d hosts: %s", len(failed), strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// writeFull write all data, continue on short write
+func writeFull(w io.Writer, data []byte) error {
+	for len(data) > 0 {
+		n, err := w.Write(data)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		data = data[n:]
+	}
+	return nil
 }
 
 // ClosePipe close ssh sessions
 func (rc *RemoteCommand) ClosePipe() {
-	for _, sess := range rc.Running {
+	for _, sess := range rc.runningSessions() {
 		sess.Signal(ssh.SIGTERM)
 		sess.Close()
 	}
 }
 
-// PrettyPrint print output and errors
+// Close interrupt commands in flight on every host by SIGINT then SIGTERM and close their sessions,
+// safe to call from another goroutine while Start is running
+func (rc *RemoteCommand) Close() {
+	for _, sess := range rc.runningSessions() {
+		sess.Signal(ssh.SIGINT)
+		sess.Signal(ssh.SIGTERM)
+		sess.Close()
+	}
+}
+
+// runningSessions copy of Running taken under lock
+func (rc *RemoteCommand) runningSessions() []*ssh.Session {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	sessions := make([]*ssh.Session, 0, len(rc.Running))
+	for _, sess := range rc.Running {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// hostList hosts in input order without duplicates
+func (rc *RemoteCommand) hostList() []string {
+	return UniqueHosts(rc.Hosts)
+}
+
+// PrettyPrint print output and errors in input host order, or SortHosts order if set
 func (rc *RemoteCommand) PrettyPrint(wo io.Writer, we io.Writer, noHeader bool, noHost bool) {
+	if rc.PrintSummary {
+		defer func() { fmt.Fprintln(we, rc.Summary()) }()
+	}
+	if rc.ShowBanner && len(rc.Banner) > 0 {
+		if !noHeader {
+			fmt.Fprintln(wo, "================================= BANNER =================================")
+		}
+		for _, h := range rc.printHosts() {
+			if b, ok := rc.Banner[h]; ok {
+				fmt.Fprintln(wo, colorize(rc.Color, colorCyan, h), ":\n", strings.TrimRight(b, "\n"))
+			}
+		}
+	}
 	if len(rc.Error) > 0 && !noHost {
 		if !noHeader {
-			we.Write([]byte("================================= ERROR =================================\n"))
+			fmt.Fprintln(we, colorize(rc.Color, colorRed, "================================= ERROR ================================="))
 		}
-		for h, e := range rc.Error {
+		for _, h := range rc.printHosts() {
+			e, ok := rc.Error[h]
+			if !ok {
+				continue
+			}
 			e = strings.TrimRight(e, "\n")
+			ch, ce := colorize(rc.Color, colorCyan, h), colorize(rc.Color, colorRed, e)
 			if strings.Contains(e, "\n") {
-				fmt.Fprintln(we, h, ":\n", e)
+				fmt.Fprintln(we, ch, ":\n", ce)
 			} else {
-				fmt.Fprintln(we, h, ":", e)
+				fmt.Fprintln(we, ch, ":", ce)
 			}
 		}
 	}
-	if len(rc.Output) > 0 {
-		if !noHeader {
-			fmt.Fprintln(wo, "================================= OUTPUT =================================")
+	if rc.ErrorsOnly {
+		return
+	}
+	hosts := rc.printHosts()
+	if rc.FailedOnly {
+		hosts = rc.sortHosts(rc.FailedHosts())
+	}
+	if len(rc.Output) > 0 && len(hosts) > 0 {
+		if !noHeader && rc.DryRun {
+			fmt.Fprintln(wo, colorize(rc.Color, colorGreen, "============================ DRY RUN, NOTHING EXECUTED ============================"))
+		} else if !noHeader {
+			fmt.Fprintln(wo, colorize(rc.Color, colorGreen, "================================= OUTPUT ================================="))
 		}
-		for h, o := range rc.Output {
-			if C.Gzip {
-				gr, err := gzip.NewReader(strings.NewReader(o))
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				defer gr.Close()
-				data, err := ioutil.ReadAll(gr)
-				if err != nil {
-					log.Println(err)
-				}
-				data = bytes.TrimRight(data, "\n")
-				if !noHost {
-					fmt.Fprintf(wo, "%15s: ", h)
-					if bytes.Contains(data, []byte("\n")) {
-						wo.Write([]byte("\n"))
-					}
+		for _, h := range hosts {
+			o, ok := rc.Output[h]
+			if !ok {
+				continue
+			}
+			if rc.MarkEmpty && rc.emptyOutput(h) {
+				o = "(empty)"
+			} else if C.Gzip && !rc.DryRun && !rc.StripANSI && rc.OutputFilter == nil && len(rc.Redact) == 0 &&
+				rc.HeadLines <= 0 && rc.TailLines <= 0 {
+				if err := rc.printGzipOutput(wo, h, o, noHost); err != nil {
+					L.Errorf("print output of %s: %v", h, err)
 				}
-				wo.Write(data)
-				wo.Write([]byte("\n"))
 				continue
+			} else {
+				var err error
+				if o, err = rc.decode(o); err != nil {
+					L.Errorf("print output of %s: %v", h, err)
+					continue
+				}
 			}
 			o = strings.TrimRight(o, "\n")
 			if !noHost {
-				fmt.Fprintf(wo, "%15s: ", h)
+				fmt.Fprint(wo, colorize(rc.Color, colorCyan, fmt.Sprintf("%15s", h)), ": ")
 				if strings.Contains(o, "\n") {
 					wo.Write([]byte("\n"))
 				}
@@ -187,3 +1389,100 @@ func (rc *RemoteCommand) PrettyPrint(wo io.Writer, we io.Writer, noHeader bool,
 		}
 	}
 }
+
+// printGzipOutput decompress output of host and stream it to wo, formatted like plain output
+func (rc *RemoteCommand) printGzipOutput(wo io.Writer, h, o string, noHost bool) error {
+	gr, err := gzipReader(strings.NewReader(o))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	br := bufio.NewReader(gr)
+	first, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	// look past line breaks to know whether more lines follow
+	newlines := 0
+	if strings.HasSuffix(first, "\n") {
+		first = first[:len(first)-1]
+		newlines = 1
+	}
+	var c byte
+	for {
+		if c, err = br.ReadByte(); err != nil || c != '\n' {
+			break
+		}
+		newlines++
+	}
+	multiline := err == nil
+	if multiline {
+		br.UnreadByte()
+	}
+	if !noHost {
+		fmt.Fprint(wo, colorize(rc.Color, colorCyan, fmt.Sprintf("%15s", h)), ": ")
+		if multiline {
+			wo.Write([]byte("\n"))
+		}
+	}
+	wo.Write([]byte(first))
+	if multiline {
+		wo.Write(bytes.Repeat([]byte("\n"), newlines))
+		_, err = io.Copy(&trimNewlineWriter{w: wo}, br)
+	} else if err == io.EOF {
+		err = nil
+	}
+	wo.Write([]byte("\n"))
+	return err
+}
+
+// lockedBuffer buffer safe for stdout and stderr copied by different goroutines
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes content written, call after writers are done
+func (b *lockedBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Bytes()
+}
+
+// trimNewlineWriter hold back line breaks until other bytes follow, trailing line breaks are dropped
+type trimNewlineWriter struct {
+	w       io.Writer
+	pending int
+}
+
+func (t *trimNewlineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			i = len(p)
+		}
+		if i > 0 {
+			if t.pending > 0 {
+				if _, err := t.w.Write(bytes.Repeat([]byte("\n"), t.pending)); err != nil {
+					return 0, err
+				}
+				t.pending = 0
+			}
+			if _, err := t.w.Write(p[:i]); err != nil {
+				return 0, err
+			}
+			p = p[i:]
+			continue
+		}
+		t.pending++
+		p = p[1:]
+	}
+	return n, nil
+}
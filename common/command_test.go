@@ -0,0 +1,24 @@
+package common
+
+import "testing"
+
+func TestHostAddr(t *testing.T) {
+	port := C.Server.DefaultPort
+	C.Server.DefaultPort = 22
+	defer func() { C.Server.DefaultPort = port }()
+	for _, c := range []struct {
+		host, want string
+	}{
+		{"1.2.3.4", "1.2.3.4:22"},
+		{"1.2.3.4:2222", "1.2.3.4:2222"},
+		{"host", "host:22"},
+		{"host:22", "host:22"},
+		{"fe80::1", "[fe80::1]:22"},
+		{"[::1]", "[::1]:22"},
+		{"[::1]:22", "[::1]:22"},
+	} {
+		if got := hostAddr(c.host); got != c.want {
+			t.Errorf("hostAddr(%q) = %q, want %q", c.host, got, c.want)
+		}
+	}
+}
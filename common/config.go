@@ -1,8 +1,10 @@
 package common
 
 import (
+	"fmt"
 	"io/ioutil"
-	"os"
+	"math"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -11,12 +13,15 @@ import (
 
 // AuthConfig configures for host authorization
 type AuthConfig struct {
-	User              string `yaml:"user"`
-	Password          string `yaml:"password"`
-	PrivateKey        string `yaml:"private_key"`
-	PrivateKeyContent string `yaml:"private_key_content"`
-	PrivateKeyPhrase  string `yaml:"private_key_phrase"`
-	PlainPassword     bool   `yaml:"plain_password"` // 是否是明文的密码(通用password和phrase)
+	User              string   `yaml:"user"`
+	Password          string   `yaml:"password"`
+	PasswordFile      string   `yaml:"password_file"` // plain password file used if password is empty
+	PrivateKey        string   `yaml:"private_key"`
+	PrivateKeyContent string   `yaml:"private_key_content"`
+	PrivateKeyPhrase  string   `yaml:"private_key_phrase"`
+	Keys              []string `yaml:"keys"`           // more private keys tried in order after private_key
+	Answers           []string `yaml:"answers"`        // pre-supplied keyboard-interactive answers
+	PlainPassword     bool     `yaml:"plain_password"` // 是否是明文的密码(通用password和phrase)
 }
 
 // Configure global configure
@@ -26,8 +31,20 @@ type Configure struct {
 	Auth AuthConfig        `yaml:"auth"`
 	Tags map[string]string `yaml:"tags"` // shortcut for frequently used commands
 	Gzip bool              `yaml:"-"`    // enable gzip transfer
+	// gzip compression level 1-9 when gzip is enabled, 0 is gzip default
+	GzipLevel int    `yaml:"gzip_level"`
+	GzipPath  string `yaml:"gzip_path"` // gzip at remote host, default gzip in PATH
 	//DefaultGroup string              `yaml:"default_group"` // set default host group
 	TransferMaxSize int64 `yaml:"transfer_max_size"`
+	// sqlite file each run is recorded to, needs build tag sqlite
+	History string `yaml:"history"`
+	// endpoint posted a json summary after each run
+	Webhook Webhook `yaml:"webhook"`
+	// kubectl used to run commands in pods, default kubectl in PATH
+	Kubectl string `yaml:"kubectl"`
+	// regexps and literal secrets masked as *** in output and errors
+	Redact        []string `yaml:"redact"`
+	RedactStrings []string `yaml:"redact_strings"`
 }
 
 // Server server groups and default port/group config
@@ -35,8 +52,40 @@ type Server struct {
 	DefaultGroup string              `yaml:"default_group"`
 	DefaultPort  int                 `yaml:"default_port"`
 	Hosts        map[string][]string `yaml:"hosts"`
+	MaxParallel  int                 `yaml:"max_parallel"`  // max hosts executing at once, 0=unlimited
+	DialRate     float64             `yaml:"dial_rate"`     // max new connections per second, 0=unlimited
+	ConfirmHosts int                 `yaml:"confirm_hosts"` // ask before running on more hosts, 0=never
+	// tcp connect and ssh handshake timeout, default 10s
+	ConnectTimeout time.Duration   `yaml:"connect_timeout"`
+	JumpHost       string          `yaml:"jump_host"`  // bastion to reach hosts through
+	JumpAuth       AuthConfig      `yaml:"jump_auth"`  // auth of bastion, default same as auth
+	SSHConfig      string          `yaml:"ssh_config"` // resolve host aliases by ssh config file
+	Inventory      string          `yaml:"inventory"`  // ansible like inventory file, replaces hosts
+	EC2            EC2Discovery    `yaml:"ec2"`        // discovery of hosts by -ec2 tags
+	Consul         ConsulDiscovery `yaml:"consul"`     // discovery of hosts by -consul service
+	// ping connections at this interval to detect dead ones, 0 disables
+	KeepAlive    time.Duration `yaml:"keepalive"`
+	KeepAliveMax int           `yaml:"keepalive_max"` // missed replies before host is failed, default 3
+	// socks5 proxy host:port to reach hosts and jump host through
+	Socks5         string `yaml:"socks5"`
+	Socks5User     string `yaml:"socks5_user"`
+	Socks5Password string `yaml:"socks5_password"` // encrypted unless plain_password
+	// algorithms offered in handshake, empty uses secure defaults
+	Ciphers      []string `yaml:"ciphers"`
+	KeyExchanges []string `yaml:"kex"`
+	MACs         []string `yaml:"macs"`
+	// pty terminal type, default xterm-256color, and LANG sent with it, default local LANG
+	TermType string `yaml:"term_type"`
+	Locale   string `yaml:"locale"`
+	// verify host key against known_hosts
+	StrictHostKey bool   `yaml:"strict_host_key"`
+	KnownHosts    string `yaml:"known_hosts"`   // default ~/.ssh/known_hosts
+	HostKeyTOFU   bool   `yaml:"host_key_tofu"` // append unknown host keys on first connect
 }
 
+// DefaultConnectTimeout default tcp connect and ssh handshake timeout
+const DefaultConnectTimeout = 10 * time.Second
+
 // C exported parsed configure
 var C *Configure
 
@@ -54,46 +103,86 @@ func ParseConfig(f string) error {
 	if err != nil {
 		return err
 	}
+	if err = checkGzipLevel(); err != nil {
+		return err
+	}
+	return checkDialRate()
+}
+
+// checkDialRate validate configured dial rate, at most one connection per nanosecond
+func checkDialRate() error {
+	if r := C.Server.DialRate; math.IsNaN(r) || r < 0 || r > 1e9 {
+		return fmt.Errorf("dial_rate must be 0-1e9 connections per second, got %v", r)
+	}
 	return nil
 }
 
+// checkGzipLevel validate configured gzip level
+func checkGzipLevel() error {
+	if C.GzipLevel < 0 || C.GzipLevel > 9 {
+		return fmt.Errorf("gzip_level must be 1-9, got %d", C.GzipLevel)
+	}
+	return nil
+}
+
+// connectTimeout get configured connect timeout or default
+func connectTimeout() time.Duration {
+	if C.Server.ConnectTimeout > 0 {
+		return C.Server.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
 // GetAuth get auth method list from configs
 func GetAuth() (auth []ssh.AuthMethod, err error) {
-	password := C.Auth.Password
-	if !C.Auth.PlainPassword {
-		password = string(Decrypt(C.Auth.Password))
+	return authMethods(&C.Auth)
+}
+
+// authMethods get auth method list of an auth config
+func authMethods(a *AuthConfig) (auth []ssh.AuthMethod, err error) {
+	password, err := AuthPassword(a)
+	if err != nil {
+		return nil, err
 	}
-	if C.Auth.PrivateKey != "" {
-		if _, err := os.Stat(C.Auth.PrivateKey); err != nil {
-			return nil, err
-		}
-		key, err := ioutil.ReadFile(C.Auth.PrivateKey)
+	var signers []ssh.Signer
+	if a.PrivateKey != "" {
+		signer, err := loadSigner(a.PrivateKey, a.PrivateKeyPhrase, a.PlainPassword)
 		if err != nil {
 			return nil, err
 		}
-		var signer ssh.Signer
-		if C.Auth.PrivateKeyPhrase == "" {
-			signer, err = ssh.ParsePrivateKey(key)
-		} else {
-			passphrase := []byte(C.Auth.PrivateKeyPhrase)
-			if !C.Auth.PlainPassword {
-				passphrase = Decrypt(C.Auth.PrivateKeyPhrase)
-			}
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
-		}
+		signers = append(signers, signer)
+	}
+	for _, k := range a.Keys {
+		signer, err := loadSigner(expandHome(k), a.PrivateKeyPhrase, a.PlainPassword)
 		if err != nil {
-			return nil, err
-		}
-		auth = []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		}
-		if password != "" {
-			auth = append(auth, ssh.Password(password))
-		}
-	} else {
-		auth = []ssh.AuthMethod{
-			ssh.Password(password),
+			L.Warnf("skip key: %v", err)
+			continue
 		}
+		signers = append(signers, signer)
+	}
+	// keys held by ssh-agent are tried before configured key
+	if ac := sshAgent(); ac != nil {
+		keys := signers
+		auth = append(auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			agentSigners, err := ac.Signers()
+			if err != nil {
+				return keys, nil
+			}
+			return append(agentSigners, keys...), nil
+		}))
+	} else if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	} else if pp := promptPassword(a.User); pp != nil {
+		auth = append(auth, pp)
+	} else if len(auth) == 0 {
+		auth = append(auth, ssh.Password(password))
+	}
+	// only used when server offers keyboard-interactive, e.g. OTP prompts
+	if ki := keyboardInteractive(a.Answers); ki != nil {
+		auth = append(auth, ki)
 	}
 	return
 }
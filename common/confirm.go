@@ -0,0 +1,41 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Confirm ask on terminal whether to run what on n hosts, see Ask
+func Confirm(what string, n int) (bool, error) {
+	if r := []rune(what); len(r) > 200 {
+		what = string(r[:200]) + "..."
+	}
+	return Ask(fmt.Sprintf("About to run %s on %d hosts. Continue?", what, n))
+}
+
+// Ask ask yes/no question on terminal, true only for y or yes. the terminal is used
+// even if stdin is redirected, like with -host -
+func Ask(question string) (bool, error) {
+	in, out := os.Stdin, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	} else if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errors.New("confirmation needs a terminal, use -yes to skip it")
+	}
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultConsulAddress consul agent used if neither config nor CONSUL_HTTP_ADDR set one
+const DefaultConsulAddress = "http://127.0.0.1:8500"
+
+// ConsulDiscovery settings of consul host discovery
+type ConsulDiscovery struct {
+	Address    string `yaml:"address"`    // default CONSUL_HTTP_ADDR or local agent
+	Token      string `yaml:"token"`      // acl token, default CONSUL_HTTP_TOKEN
+	Datacenter string `yaml:"datacenter"` // default datacenter of agent
+}
+
+// consulEntry part of /v1/health/service entry used for discovery
+type consulEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// DiscoverConsul host:port of instances of service passing all health checks, service may be
+// followed by a tag like web:canary. Port of catalog overrides default port
+func DiscoverConsul(ctx context.Context, service string) ([]string, error) {
+	cc := C.Server.Consul
+	addr := cc.Address
+	if addr == "" {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if addr == "" {
+		addr = DefaultConsulAddress
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	token := cc.Token
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	name, tag := service, ""
+	if i := strings.Index(service, ":"); i >= 0 {
+		name, tag = service[:i], service[i+1:]
+	}
+	q := url.Values{"passing": {"true"}}
+	if tag != "" {
+		q.Set("tag", tag)
+	}
+	if cc.Datacenter != "" {
+		q.Set("dc", cc.Datacenter)
+	}
+	u := strings.TrimRight(addr, "/") + "/v1/health/service/" + url.PathEscape(name) + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("consul: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul: service %s: %s", service, resp.Status)
+	}
+	var entries []consulEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("consul: service %s: %v", service, err)
+	}
+	var hosts []string
+	for _, e := range entries {
+		// service address is empty when it is the node address
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		if e.Service.Port > 0 {
+			host = net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("consul: no passing instances of service %s", service)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
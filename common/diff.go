@@ -0,0 +1,220 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DiffBaselineAuto use the most common output as baseline
+const DiffBaselineAuto = "auto"
+
+// diffContext lines of context around changes
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	a, b int // line index in baseline and target when op happens
+}
+
+// PrettyPrintDiff print unified diff of every host whose output differs from baseline host.
+// baseline can be DiffBaselineAuto or empty to use the host with most common output
+func (rc *RemoteCommand) PrettyPrintDiff(w io.Writer, baseline string) error {
+	groups := rc.GroupedOutput()
+	if len(groups) == 0 {
+		return nil
+	}
+	var base string
+	if baseline == "" || baseline == DiffBaselineAuto {
+		baseline = groups[0].Hosts[0]
+		base = groups[0].Output
+	} else {
+		found := false
+		for _, g := range groups {
+			for _, h := range g.Hosts {
+				if h == baseline {
+					base, found = g.Output, true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("baseline host has no output: %s", baseline)
+		}
+	}
+	baseLines := splitLines(base)
+	same := 0
+	for _, g := range groups {
+		if g.Output == base {
+			same = len(g.Hosts)
+			continue
+		}
+		// hosts of a group have the same output, so the same diff
+		ops := diffLines(baseLines, splitLines(g.Output))
+		for _, h := range g.Hosts {
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", baseline, h)
+			writeHunks(w, ops)
+		}
+	}
+	fmt.Fprintf(w, "%d hosts same as baseline %s\n", same, baseline)
+	return nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines line based diff, common prefix and suffix are matched first and the rest by
+// the linear space variant of Myers' algorithm, so memory stays proportional to the outputs
+func diffLines(a, b []string) []diffOp {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.ops
+}
+
+// differ diff state of a and b, ops are appended in order
+type differ struct {
+	a, b []string
+	ops  []diffOp
+}
+
+// compare diff a[a0:a1] against b[b0:b1]
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.ops = append(d.ops, diffOp{' ', d.a[a0], a0, b0})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a1-suffix > a0 && b1-suffix > b0 && d.a[a1-suffix-1] == d.b[b1-suffix-1] {
+		suffix++
+	}
+	a1 -= suffix
+	b1 -= suffix
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			d.ops = append(d.ops, diffOp{'+', d.b[j], a0, j})
+		}
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			d.ops = append(d.ops, diffOp{'-', d.a[i], i, b0})
+		}
+	default:
+		x, y, u, v := d.middleSnake(a0, a1, b0, b1)
+		d.compare(a0, a0+x, b0, b0+y)
+		for i, j := a0+x, b0+y; i < a0+u; i, j = i+1, j+1 {
+			d.ops = append(d.ops, diffOp{' ', d.a[i], i, j})
+		}
+		d.compare(a0+u, a1, b0+v, b1)
+	}
+	for k := 0; k < suffix; k++ {
+		d.ops = append(d.ops, diffOp{' ', d.a[a1+k], a1 + k, b1 + k})
+	}
+}
+
+// middleSnake snake (x,y)-(u,v) relative to a0,b0 in the middle of a shortest edit path of
+// a[a0:a1] and b[b0:b1], found by searching forward from the start and backward from the end
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	off := max + 1
+	vf := make([]int, 2*max+3)
+	vb := make([]int, 2*max+3)
+	for e := 0; e <= max; e++ {
+		for k := -e; k <= e; k += 2 {
+			if k == -e || k != e && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && d.a[a0+u] == d.b[b0+v] {
+				u++
+				v++
+			}
+			vf[off+k] = u
+			if c := delta - k; odd && c >= -(e-1) && c <= e-1 && u+vb[off+c] >= n {
+				return x, y, u, v
+			}
+		}
+		for c := -e; c <= e; c += 2 {
+			if c == -e || c != e && vb[off+c-1] < vb[off+c+1] {
+				x = vb[off+c+1]
+			} else {
+				x = vb[off+c-1] + 1
+			}
+			y = x - c
+			u, v = x, y
+			for u < n && v < m && d.a[a1-u-1] == d.b[b1-v-1] {
+				u++
+				v++
+			}
+			vb[off+c] = u
+			if k := delta - c; !odd && k >= -e && k <= e && u+vf[off+k] >= n {
+				return n - u, m - v, n - x, m - y
+			}
+		}
+	}
+	// not reached, the paths always meet by max
+	return 0, 0, n, m
+}
+
+// writeHunks write ops as unified diff hunks
+func writeHunks(w io.Writer, ops []diffOp) {
+	for start := 0; start < len(ops); {
+		// next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start >= len(ops) {
+			return
+		}
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		// extend while changes are close enough to share context
+		end, last := start, start
+		for end < len(ops) && end-last <= 2*diffContext {
+			if ops[end].kind != ' ' {
+				last = end
+			}
+			end++
+		}
+		to := last + diffContext + 1
+		if to > len(ops) {
+			to = len(ops)
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(ops[from].a, aCount), hunkRange(ops[from].b, bCount))
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(w, "%c%s\n", op.kind, op.line)
+		}
+		start = to
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// discoveryCacheFile file caching discovered hosts of key under user cache dir
+func discoveryCacheFile(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(dir, "optool", "hosts-"+hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// cachedHosts hosts discovered for key within ttl, so repeated runs skip the api
+func cachedHosts(key string, ttl time.Duration) ([]string, bool) {
+	if ttl <= 0 {
+		return nil, false
+	}
+	f, err := discoveryCacheFile(key)
+	if err != nil {
+		return nil, false
+	}
+	st, err := os.Stat(f)
+	if err != nil || time.Since(st.ModTime()) > ttl {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, false
+	}
+	var hosts []string
+	if json.Unmarshal(data, &hosts) != nil {
+		return nil, false
+	}
+	L.Debugf("discovery: %d cached hosts of %s", len(hosts), key)
+	return hosts, true
+}
+
+// cacheHosts save discovered hosts of key, failure only costs another api call next time
+func cacheHosts(key string, hosts []string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	f, err := discoveryCacheFile(key)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(f), 0700)
+	}
+	if err == nil {
+		data, _ := json.Marshal(hosts)
+		err = ioutil.WriteFile(f, data, 0600)
+	}
+	if err != nil {
+		L.Debugf("discovery: cache hosts of %s: %v", key, err)
+	}
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Download fetch remotePath of every host into localDir/<host>/, see DownloadContext
+func (rc *RemoteCommand) Download(remotePath, localDir string) error {
+	return rc.DownloadContext(context.Background(), remotePath, localDir)
+}
+
+// DownloadContext fetch remotePath of every host into localDir/<host>/<remote path> by sftp.
+// remotePath may be a glob like /var/log/app/*.log, directories matched are skipped.
+// Per-host failures are recorded in Error
+func (rc *RemoteCommand) DownloadContext(ctx context.Context, remotePath, localDir string) error {
+	if rc.PipeMode {
+		return errors.New("download is not supported in pipe mode")
+	}
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+	total := newThrottle(rc.BandwidthLimit)
+	return rc.run(ctx, func(ctx context.Context, ohost string, client *ssh.Client, timing *HostTiming, ready func()) {
+		ts := time.Now()
+		// per host limit is shared by all files of host
+		host := newThrottle(rc.HostBandwidthLimit)
+		limit := func(r io.Reader) io.Reader { return throttleReader(ctx, r, total, host) }
+		files, err := downloadFiles(client, remotePath, filepath.Join(localDir, hostFileName(ohost)), limit)
+		timing.Command = time.Since(ts)
+		rc.lock.Lock()
+		if len(files) > 0 {
+			rc.Output[ohost] = strings.Join(files, "\n")
+		}
+		if err != nil {
+			rc.recordError(ohost, err)
+		}
+		rc.lock.Unlock()
+	})
+}
+
+// downloadFiles fetch files matching pattern into dir keeping remote path, read through limit.
+// get lines of fetched files
+func downloadFiles(client *ssh.Client, pattern, dir string, limit func(io.Reader) io.Reader) ([]string, error) {
+	sc, err := sftp.NewClient(client, sftp.MaxPacket(33788))
+	if err != nil {
+		return nil, err
+	}
+	defer sc.Close()
+	matches, err := sc.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	var errs []string
+	for _, m := range matches {
+		fi, err := sc.Stat(m)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if fi.IsDir() {
+			continue
+		}
+		if fi.Size() > C.TransferMaxSize && C.TransferMaxSize > 0 {
+			errs = append(errs, fmt.Sprintf("%s: Max transfer size is set to %d", m, C.TransferMaxSize))
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(m, "/")))
+		size, err := downloadFile(sc, m, target, limit)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", m, err))
+			continue
+		}
+		files = append(files, fmt.Sprintf("%s => %s %dByte", m, target, size))
+	}
+	if len(files) == 0 && len(errs) == 0 {
+		return nil, fmt.Errorf("%s: file not found", pattern)
+	}
+	if len(errs) > 0 {
+		return files, errors.New(strings.Join(errs, "\n"))
+	}
+	return files, nil
+}
+
+func downloadFile(sc *sftp.Client, remotePath, localPath string, limit func(io.Reader) io.Reader) (int64, error) {
+	src, err := sc.Open(remotePath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return 0, err
+	}
+	dst, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(dst, limit(src))
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return size, err
+}
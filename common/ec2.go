@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// DefaultEC2CacheTTL time discovered ec2 hosts are reused if not configured
+const DefaultEC2CacheTTL = time.Minute
+
+// EC2Discovery settings of ec2 host discovery, credentials come from the usual aws sources
+type EC2Discovery struct {
+	Region   string        `yaml:"region"`    // default region of aws config
+	PublicIP bool          `yaml:"public_ip"` // target public instead of private ips
+	CacheTTL time.Duration `yaml:"cache_ttl"` // reuse discovered hosts this long, default 1m, <0 disables
+}
+
+// ec2Filters filters of running instances having tags like Role=web,Env=prod
+func ec2Filters(tags string) ([]types.Filter, error) {
+	filters := []types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running"}}}
+	for _, kv := range strings.Split(tags, ",") {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("bad ec2 tag filter %q, want key=value", kv)
+		}
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + kv[:i]), Values: []string{kv[i+1:]}})
+	}
+	return filters, nil
+}
+
+// DiscoverEC2 ips of running ec2 instances having all tags like Role=web,Env=prod, sorted so
+// host order is stable. Results are cached for C.Server.EC2.CacheTTL
+func DiscoverEC2(ctx context.Context, tags string) ([]string, error) {
+	ec := C.Server.EC2
+	filters, err := ec2Filters(tags)
+	if err != nil {
+		return nil, err
+	}
+	ttl := ec.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultEC2CacheTTL
+	}
+	key := fmt.Sprintf("ec2 region=%s public=%v %s", ec.Region, ec.PublicIP, tags)
+	if hosts, ok := cachedHosts(key, ttl); ok {
+		return hosts, nil
+	}
+	var opts []func(*config.LoadOptions) error
+	if ec.Region != "" {
+		opts = append(opts, config.WithRegion(ec.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("aws config: %v", err)
+	}
+	var hosts []string
+	pages := ec2.NewDescribeInstancesPaginator(ec2.NewFromConfig(cfg), &ec2.DescribeInstancesInput{Filters: filters})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describe ec2 instances: %v", err)
+		}
+		for _, r := range page.Reservations {
+			for _, inst := range r.Instances {
+				ip := aws.ToString(inst.PrivateIpAddress)
+				if ec.PublicIP {
+					ip = aws.ToString(inst.PublicIpAddress)
+				}
+				if ip == "" {
+					L.Warnf("ec2 instance %s has no ip to connect, skipped", aws.ToString(inst.InstanceId))
+					continue
+				}
+				hosts = append(hosts, ip)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no running ec2 instances have tags %s", tags)
+	}
+	sort.Strings(hosts)
+	cacheHosts(key, hosts, ttl)
+	return hosts, nil
+}
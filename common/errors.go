@@ -0,0 +1,80 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	errTimedOut = errors.New("timed out") // wrapped by TimeoutError and other errors of commands stopped by a timeout
+	errCanceled = errors.New("canceled")  // wrapped by errors of hosts stopped by cancel or abort
+)
+
+// DialError failure connecting to host: dns, tcp, handshake or host key
+type DialError struct {
+	Host string
+	Err  error
+}
+
+func (e *DialError) Error() string { return e.Err.Error() }
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+// AuthError host rejected all auth methods or keys could not be loaded
+type AuthError struct {
+	Host string
+	Err  error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// ExecError failure running command after connected, including non-zero exit
+type ExecError struct {
+	Host     string
+	ExitCode int // -1 if command did not exit normally
+	Err      error
+}
+
+func (e *ExecError) Error() string { return e.Err.Error() }
+
+func (e *ExecError) Unwrap() error { return e.Err }
+
+// TimeoutError command of host killed after running longer than its timeout, other hosts
+// are not affected. Wrapped by ExecError. Run is set for hosts stopped or never started
+// because RunTimeout of the whole run expired
+type TimeoutError struct {
+	Host    string
+	Timeout time.Duration
+	Run     bool
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Run {
+		return fmt.Sprintf("run timed out after %s", e.Timeout)
+	}
+	return fmt.Sprintf("command timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error { return errTimedOut }
+
+// connectError wrap error of dialing host as AuthError or DialError
+func connectError(host string, err error) error {
+	// x/crypto has no typed error for rejected auth
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return &AuthError{Host: host, Err: err}
+	}
+	return &DialError{Host: host, Err: err}
+}
+
+// execError wrap error of running command on host
+func execError(host string, err error) error {
+	code, ok := exitCode(err)
+	if !ok {
+		code = -1
+	}
+	return &ExecError{Host: host, ExitCode: code, Err: err}
+}
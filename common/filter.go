@@ -0,0 +1,64 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keepLine whether line of output passes OutputFilter
+func (rc *RemoteCommand) keepLine(line string) bool {
+	return rc.OutputFilter == nil || rc.OutputFilter.MatchString(line) != rc.FilterInvert
+}
+
+// filterOutput keep lines of output passing OutputFilter, or only their count if FilterCount is set
+func (rc *RemoteCommand) filterOutput(o string) string {
+	if rc.OutputFilter == nil {
+		return o
+	}
+	var b strings.Builder
+	count := 0
+	for _, line := range strings.SplitAfter(o, "\n") {
+		if line == "" || !rc.keepLine(strings.TrimRight(line, "\r\n")) {
+			continue
+		}
+		count++
+		if !rc.FilterCount {
+			b.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				b.WriteString("\n")
+			}
+		}
+	}
+	if rc.FilterCount {
+		return strconv.Itoa(count) + "\n"
+	}
+	return b.String()
+}
+
+// truncateLines keep first HeadLines and last TailLines lines of output, omitted lines are
+// replaced by a "... N lines omitted" marker
+func (rc *RemoteCommand) truncateLines(o string) string {
+	if rc.HeadLines <= 0 && rc.TailLines <= 0 {
+		return o
+	}
+	lines := strings.SplitAfter(o, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	head, tail := rc.HeadLines, rc.TailLines
+	if head < 0 {
+		head = 0
+	}
+	if tail < 0 {
+		tail = 0
+	}
+	if head+tail >= len(lines) {
+		return o
+	}
+	// kept head lines are never the last one, so all end with newline
+	kept := append([]string{}, lines[:head]...)
+	kept = append(kept, fmt.Sprintf("... %d lines omitted\n", len(lines)-head-tail))
+	kept = append(kept, lines[len(lines)-tail:]...)
+	return strings.Join(kept, "")
+}
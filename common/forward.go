@@ -0,0 +1,142 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PortForward local listen address forwarded to remote address dialed by host, like ssh -L
+type PortForward struct {
+	Local  string // like 127.0.0.1:8080
+	Remote string // address as seen from host, like localhost:80
+}
+
+// ParsePortForward parse ssh -L like spec [bind:]port:host:hostport, bind defaults to 127.0.0.1
+func ParsePortForward(spec string) (PortForward, error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 3:
+		return PortForward{Local: net.JoinHostPort("127.0.0.1", parts[0]), Remote: net.JoinHostPort(parts[1], parts[2])}, nil
+	case 4:
+		return PortForward{Local: net.JoinHostPort(parts[0], parts[1]), Remote: net.JoinHostPort(parts[2], parts[3])}, nil
+	}
+	return PortForward{}, fmt.Errorf("invalid forward %q, want [bind:]port:host:hostport", spec)
+}
+
+// Forward serve port forwards through the only host, see ForwardContext
+func (rc *RemoteCommand) Forward(forwards ...PortForward) error {
+	return rc.ForwardContext(context.Background(), forwards...)
+}
+
+// ForwardContext listen on local addresses of forwards and tunnel each accepted connection to its
+// remote address through the only host, until ctx is canceled. Listeners and tunnels are closed then
+func (rc *RemoteCommand) ForwardContext(ctx context.Context, forwards ...PortForward) error {
+	hosts := rc.hostList()
+	if len(hosts) != 1 {
+		return fmt.Errorf("port forwarding needs exactly one host, got %d", len(hosts))
+	}
+	if len(forwards) == 0 {
+		return errors.New("no port forwards")
+	}
+	err := rc.run(ctx, func(ctx context.Context, ohost string, client *ssh.Client, timing *HostTiming, ready func()) {
+		if err := serveForwards(ctx, client, forwards); err != nil {
+			rc.setError(ohost, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if err = rc.errs[hosts[0]]; errors.Is(err, errCanceled) {
+		return nil
+	}
+	return err
+}
+
+// serveForwards tunnel connections of forwards through client until ctx is done or client is lost
+func serveForwards(ctx context.Context, client *ssh.Client, forwards []PortForward) error {
+	var lock sync.Mutex
+	conns := make(map[io.Closer]bool)
+	track := func(c io.Closer, add bool) {
+		lock.Lock()
+		if add {
+			conns[c] = true
+		} else {
+			delete(conns, c)
+		}
+		lock.Unlock()
+	}
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+		lock.Lock()
+		for c := range conns {
+			c.Close()
+		}
+		lock.Unlock()
+	}()
+	for _, f := range forwards {
+		l, err := net.Listen("tcp", f.Local)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+		L.Infof("forward %s => %s", l.Addr(), f.Remote)
+		go acceptForward(l, client, f.Remote, track)
+	}
+	lost := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(lost)
+	}()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-lost:
+		return errors.New("connection lost")
+	}
+}
+
+// acceptForward tunnel connections accepted by l to remote until l is closed
+func acceptForward(l net.Listener, client *ssh.Client, remote string, track func(io.Closer, bool)) {
+	for {
+		local, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer local.Close()
+			rconn, err := client.Dial("tcp", remote)
+			if err != nil {
+				L.Warnf("forward to %s: %v", remote, err)
+				return
+			}
+			defer rconn.Close()
+			track(local, true)
+			track(rconn, true)
+			defer track(local, false)
+			defer track(rconn, false)
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(rconn, local)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(local, rconn)
+				done <- struct{}{}
+			}()
+			// either side closing ends the tunnel
+			<-done
+		}()
+	}
+}
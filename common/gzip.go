@@ -0,0 +1,40 @@
+package common
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// DefaultGzipPath gzip of remote host, resolved by PATH
+const DefaultGzipPath = "gzip"
+
+// gzipMissing stderr line and exit status of command when gzip is not found at remote host
+const (
+	gzipMissing     = "optool: gzip not found at remote host"
+	gzipMissingCode = 127
+)
+
+// gzipCommand wrap cmd to compress its output, failing early if gzip is missing at remote host
+func gzipCommand(cmd string) string {
+	path := C.GzipPath
+	if path == "" {
+		path = DefaultGzipPath
+	}
+	gz := shellQuote(path)
+	if C.GzipLevel > 0 {
+		gz += " -" + strconv.Itoa(C.GzipLevel)
+	}
+	return fmt.Sprintf("command -v %s >/dev/null 2>&1 || { echo %s >&2; exit %d; }; %s | %s -f",
+		shellQuote(path), shellQuote(gzipMissing+": "+path), gzipMissingCode, cmd, gz)
+}
+
+// gzipReader open gzip output, explaining failure of output not being gzip
+func gzipReader(r io.Reader) (*gzip.Reader, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("output is not gzip, check gzip at remote host: %v", err)
+	}
+	return gr, nil
+}
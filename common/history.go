@@ -0,0 +1,42 @@
+package common
+
+import "time"
+
+// historyRun run row of history database
+type historyRun struct {
+	Started time.Time
+	Elapsed time.Duration
+	Command string
+	Hosts   []string
+	Results []historyResult
+}
+
+// historyResult host row of history database
+type historyResult struct {
+	Host        string
+	ExitCode    int
+	OutputBytes int
+	Error       string
+}
+
+// SaveHistory append last run and result of each host to history database at path, ~/ is expanded.
+// The database is sqlite, available only when optool is built with -tags sqlite
+func (rc *RemoteCommand) SaveHistory(path string) error {
+	rc.lock.Lock()
+	run := historyRun{
+		Started: rc.started,
+		Elapsed: rc.elapsed,
+		Command: rc.redact(rc.Cmd),
+		Hosts:   rc.hostList(),
+	}
+	rc.lock.Unlock()
+	for _, r := range rc.Result() {
+		run.Results = append(run.Results, historyResult{
+			Host:        r.Host,
+			ExitCode:    r.ExitCode,
+			OutputBytes: len(r.Stdout),
+			Error:       r.Error,
+		})
+	}
+	return saveHistory(expandHome(path), &run)
+}
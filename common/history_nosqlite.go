@@ -0,0 +1,11 @@
+//go:build !sqlite
+// +build !sqlite
+
+package common
+
+import "errors"
+
+// saveHistory history is not available without sqlite driver
+func saveHistory(path string, run *historyRun) error {
+	return errors.New("run history needs optool built with -tags sqlite")
+}
@@ -0,0 +1,63 @@
+//go:build sqlite
+// +build sqlite
+
+package common
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // sqlite driver
+)
+
+// historySchema tables of history database, created if missing
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	started    TIMESTAMP NOT NULL,
+	elapsed_ms INTEGER NOT NULL,
+	command    TEXT NOT NULL,
+	hosts      TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id       INTEGER NOT NULL REFERENCES runs(id),
+	host         TEXT NOT NULL,
+	exit_code    INTEGER NOT NULL,
+	output_bytes INTEGER NOT NULL,
+	error        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_host ON results(host);
+`
+
+// saveHistory insert run and its host results in one transaction
+func saveHistory(path string, run *historyRun) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.Exec(historySchema); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO runs (started, elapsed_ms, command, hosts) VALUES (?, ?, ?, ?)",
+		run.Started, run.Elapsed.Milliseconds(), run.Command, strings.Join(run.Hosts, ","))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, r := range run.Results {
+		if _, err = tx.Exec("INSERT INTO results (run_id, host, exit_code, output_bytes, error) VALUES (?, ?, ?, ?, ?)",
+			id, r.Host, r.ExitCode, r.OutputBytes, r.Error); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
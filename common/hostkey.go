@@ -0,0 +1,81 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultKnownHosts default known_hosts file path
+var DefaultKnownHosts = homeDir() + "/.ssh/known_hosts"
+
+// hostKeyLock protects appending new keys to known_hosts
+var hostKeyLock sync.Mutex
+
+// HostKeyCallback get host key callback from configs
+func HostKeyCallback() (ssh.HostKeyCallback, error) {
+	if !C.Server.StrictHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	khPath := C.Server.KnownHosts
+	if khPath == "" {
+		khPath = DefaultKnownHosts
+	}
+	if C.Server.HostKeyTOFU {
+		// known_hosts may not exist before first connect
+		f, err := os.OpenFile(khPath, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+	check, err := knownhosts.New(khPath)
+	if err != nil {
+		return nil, err
+	}
+	// keys appended in this run, knownhosts.New does not reload the file
+	added := make(map[string]ssh.PublicKey)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) {
+			return err
+		}
+		if len(ke.Want) > 0 {
+			w := ke.Want[0]
+			return fmt.Errorf("host key mismatch for %s: got %s, want %s (%s:%d)", hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(w.Key), w.Filename, w.Line)
+		}
+		if !C.Server.HostKeyTOFU {
+			return fmt.Errorf("unknown host key for %s: %s not found in %s", hostname, ssh.FingerprintSHA256(key), khPath)
+		}
+		return appendKnownHost(khPath, hostname, key, added)
+	}, nil
+}
+
+// appendKnownHost trust a newly seen host key and save it to known_hosts
+func appendKnownHost(khPath, hostname string, key ssh.PublicKey, added map[string]ssh.PublicKey) error {
+	hostKeyLock.Lock()
+	defer hostKeyLock.Unlock()
+	host := knownhosts.Normalize(hostname)
+	if k, ok := added[host]; ok {
+		if string(k.Marshal()) != string(key.Marshal()) {
+			return fmt.Errorf("host key mismatch for %s: got %s, want %s", hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(k))
+		}
+		return nil
+	}
+	f, err := os.OpenFile(khPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = fmt.Fprintln(f, knownhosts.Line([]string{host}, key)); err != nil {
+		return err
+	}
+	added[host] = key
+	return nil
+}
@@ -0,0 +1,218 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReadHosts read newline separated hosts, blank lines and # comments are skipped,
+// duplicates are dropped keeping first seen order
+func ReadHosts(r io.Reader) ([]string, error) {
+	var hosts []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return UniqueHosts(hosts), nil
+}
+
+// UniqueHosts drop duplicate hosts keeping first seen order
+func UniqueHosts(hosts []string) []string {
+	seen := make(map[string]bool, len(hosts))
+	unique := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if !seen[h] {
+			seen[h] = true
+			unique = append(unique, h)
+		}
+	}
+	return unique
+}
+
+// ExcludeHosts drop hosts equal to or matching glob patterns like web-*, keeping order.
+// Patterns match user@host:port entries by host name too
+func ExcludeHosts(hosts, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return hosts, nil
+	}
+	kept := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		i, err := matchHost(h, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("exclude %v", err)
+		}
+		if i < 0 {
+			kept = append(kept, h)
+		}
+	}
+	return kept, nil
+}
+
+// MatchHosts hosts equal to or matching any of glob patterns like web-*.us-east, keeping order.
+// Patterns match user@host:port entries by host name too. A pattern matching no host is an error
+func MatchHosts(hosts, patterns []string) ([]string, error) {
+	matched := make([]string, 0, len(hosts))
+	used := make([]bool, len(patterns))
+	for _, h := range hosts {
+		hit := false
+		for i, p := range patterns {
+			j, err := matchHost(h, []string{p})
+			if err != nil {
+				return nil, err
+			}
+			if j == 0 {
+				used[i], hit = true, true
+			}
+		}
+		if hit {
+			matched = append(matched, h)
+		}
+	}
+	for i, p := range patterns {
+		if !used[i] {
+			return nil, fmt.Errorf("pattern %q matched no hosts", p)
+		}
+	}
+	return matched, nil
+}
+
+// matchHost index of first pattern host equals or matches by path.Match, -1 if none.
+// Patterns are tried on host as given and on its bare name, so web1 matches deploy@web1:2222
+func matchHost(h string, patterns []string) (int, error) {
+	names := []string{h}
+	if bare := bareHost(h); bare != h {
+		names = append(names, bare)
+	}
+	for i, p := range patterns {
+		for _, name := range names {
+			if p == name {
+				return i, nil
+			}
+			ok, err := path.Match(p, name)
+			if err != nil {
+				return -1, fmt.Errorf("pattern %q: %v", p, err)
+			}
+			if ok {
+				return i, nil
+			}
+		}
+	}
+	return -1, nil
+}
+
+// bareHost name of host without user@ and :port, IPv6 literal without brackets
+func bareHost(h string) string {
+	_, h = splitUser(h)
+	if name, _, err := net.SplitHostPort(h); err == nil {
+		return name
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+}
+
+// ExpandHosts expand range patterns of every host, see ExpandHostPattern
+func ExpandHosts(hosts []string) ([]string, error) {
+	var expanded []string
+	for _, h := range hosts {
+		hs, err := ExpandHostPattern(h)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, hs...)
+	}
+	return expanded, nil
+}
+
+// ExpandHostPattern expand bracket groups like app[01-50].example.com or app[1,3,5-7].
+// Zero padding of range start is kept, multiple groups produce every combination.
+// Brackets containing colon are IPv6 addresses and kept as is
+func ExpandHostPattern(p string) ([]string, error) {
+	start := strings.Index(p, "[")
+	if start < 0 {
+		return []string{p}, nil
+	}
+	end := strings.Index(p[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unclosed bracket in host pattern: %s", p)
+	}
+	end += start
+	body := p[start+1 : end]
+	prefix, rest := p[:start], p[end+1:]
+	if strings.Contains(body, ":") {
+		// [::1]:22
+		tails, err := ExpandHostPattern(rest)
+		if err != nil {
+			return nil, err
+		}
+		return prependAll(p[:end+1], tails), nil
+	}
+	items, err := expandRangeBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("host pattern %s: %v", p, err)
+	}
+	tails, err := ExpandHostPattern(rest)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, item := range items {
+		hosts = append(hosts, prependAll(prefix+item, tails)...)
+	}
+	return hosts, nil
+}
+
+func prependAll(prefix string, tails []string) []string {
+	hosts := make([]string, 0, len(tails))
+	for _, t := range tails {
+		hosts = append(hosts, prefix+t)
+	}
+	return hosts
+}
+
+// expandRangeBody expand "01-03,7" into 01,02,03,7
+func expandRangeBody(body string) ([]string, error) {
+	if body == "" {
+		return nil, fmt.Errorf("empty bracket")
+	}
+	var items []string
+	for _, part := range strings.Split(body, ",") {
+		i := strings.Index(part, "-")
+		if i < 0 {
+			if part == "" {
+				return nil, fmt.Errorf("empty item")
+			}
+			items = append(items, part)
+			continue
+		}
+		from, to := part[:i], part[i+1:]
+		lo, lerr := strconv.Atoi(from)
+		hi, herr := strconv.Atoi(to)
+		if lerr != nil || herr != nil {
+			// not numeric, such as [web-a,web-b]
+			items = append(items, part)
+			continue
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		width := 0
+		if len(from) > 1 && from[0] == '0' {
+			width = len(from)
+		}
+		for n := lo; n <= hi; n++ {
+			items = append(items, fmt.Sprintf("%0*d", width, n))
+		}
+	}
+	return items, nil
+}
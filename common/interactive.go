@@ -0,0 +1,85 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// promptLock serialize terminal prompts of hosts connecting in parallel
+var promptLock sync.Mutex
+
+// keyboardInteractive get keyboard-interactive auth answering server prompts with answers
+// in order, then from terminal. nil if there are no answers and stdin is not a terminal
+func keyboardInteractive(answers []string) ssh.AuthMethod {
+	fd := int(os.Stdin.Fd())
+	isTerm := terminal.IsTerminal(fd)
+	if len(answers) == 0 && !isTerm {
+		return nil
+	}
+	return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			return nil, nil
+		}
+		if len(questions) <= len(answers) {
+			return answers[:len(questions)], nil
+		}
+		if !isTerm {
+			return nil, errors.New("not enough answers for keyboard-interactive prompts")
+		}
+		promptLock.Lock()
+		defer promptLock.Unlock()
+		if instruction != "" {
+			fmt.Fprintln(os.Stderr, instruction)
+		}
+		replies := make([]string, len(questions))
+		for i, q := range questions {
+			if i < len(answers) {
+				replies[i] = answers[i]
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "(%s) %s", user, q)
+			var reply []byte
+			var err error
+			if echos[i] {
+				reply, err = readLine()
+			} else {
+				reply, err = terminal.ReadPassword(fd)
+				fmt.Fprintln(os.Stderr)
+			}
+			if err != nil {
+				return nil, err
+			}
+			replies[i] = string(reply)
+		}
+		return replies, nil
+	})
+}
+
+// readLine read one line from stdin without buffering ahead
+func readLine() ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			if b[0] != '\r' {
+				line = append(line, b[0])
+			}
+		}
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+			return nil, err
+		}
+	}
+	return line, nil
+}
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Inventory host groups loaded from an ansible like inventory file
+//
+//	[web]
+//	web1 ansible_port=2222
+//	web2
+//	[db]
+//	db1
+//	[backend:children]
+//	web
+//	db
+//	[all:vars]
+//	ansible_port=22
+type Inventory struct {
+	DefaultPort int // port of all:vars, 0 means C.Server.DefaultPort
+	groups      map[string][]inventoryHost
+	children    map[string][]string
+	groupPort   map[string]int
+	order       []string // group names in file order
+}
+
+type inventoryHost struct {
+	name string
+	port int
+}
+
+// LoadInventory parse inventory file
+func LoadInventory(f string) (*Inventory, error) {
+	fp, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	inv := &Inventory{
+		groups:    make(map[string][]inventoryHost),
+		children:  make(map[string][]string),
+		groupPort: make(map[string]int),
+	}
+	group, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(fp)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group, kind = line[1:len(line)-1], ""
+			if i := strings.Index(group, ":"); i >= 0 {
+				group, kind = group[:i], group[i+1:]
+			}
+			if kind != "" && kind != "children" && kind != "vars" {
+				return nil, fmt.Errorf("%s:%d: unknown section type %q", f, lineNo, kind)
+			}
+			inv.addGroup(group)
+			continue
+		}
+		fields := strings.Fields(line)
+		switch kind {
+		case "children":
+			inv.addGroup(fields[0])
+			inv.children[group] = append(inv.children[group], fields[0])
+		case "vars":
+			k, v := splitVar(line)
+			if k == "ansible_port" {
+				port, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid port %q", f, lineNo, v)
+				}
+				inv.groupPort[group] = port
+			}
+		default:
+			h := inventoryHost{name: fields[0]}
+			for _, kv := range fields[1:] {
+				k, v := splitVar(kv)
+				if k == "ansible_port" {
+					if h.port, err = strconv.Atoi(v); err != nil {
+						return nil, fmt.Errorf("%s:%d: invalid port %q", f, lineNo, v)
+					}
+				}
+			}
+			inv.addGroup(group)
+			inv.groups[group] = append(inv.groups[group], h)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	inv.DefaultPort = inv.groupPort["all"]
+	return inv, nil
+}
+
+func splitVar(kv string) (string, string) {
+	i := strings.Index(kv, "=")
+	if i < 0 {
+		return strings.TrimSpace(kv), ""
+	}
+	return strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+}
+
+func (inv *Inventory) addGroup(name string) {
+	if _, ok := inv.groups[name]; ok {
+		return
+	}
+	inv.groups[name] = nil
+	inv.order = append(inv.order, name)
+}
+
+// HasGroup check whether group is defined
+func (inv *Inventory) HasGroup(name string) bool {
+	_, ok := inv.groups[name]
+	return ok || name == "all"
+}
+
+// Expand expand groups separated by colon (web:db) into hosts, nested groups included.
+// Every host is returned once, as host:port if it has a port: its own ansible_port, else
+// ansible_port of the first group reaching it that sets one, else port of all:vars.
+// Names holding a port or an IPv6 address already are returned as is
+func (inv *Inventory) Expand(pattern string) ([]string, error) {
+	var names []string
+	hostPort := make(map[string]int)
+	groupPort := make(map[string]int)
+	seen := make(map[string]bool)
+	add := func(h inventoryHost, port int) {
+		if !seen[h.name] {
+			seen[h.name] = true
+			names = append(names, h.name)
+		}
+		if hostPort[h.name] == 0 {
+			hostPort[h.name] = h.port
+		}
+		if groupPort[h.name] == 0 {
+			groupPort[h.name] = port
+		}
+	}
+	for _, name := range strings.Split(pattern, ":") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !inv.HasGroup(name) {
+			return nil, fmt.Errorf("inventory group not found: %s", name)
+		}
+		if err := inv.expand(name, 0, map[string]bool{}, add); err != nil {
+			return nil, err
+		}
+	}
+	hosts := make([]string, 0, len(names))
+	for _, name := range names {
+		port := hostPort[name]
+		if port == 0 {
+			port = groupPort[name]
+		}
+		if port == 0 {
+			port = inv.DefaultPort
+		}
+		if port > 0 && !strings.Contains(name, ":") {
+			name += ":" + strconv.Itoa(port)
+		}
+		hosts = append(hosts, name)
+	}
+	return hosts, nil
+}
+
+// expand walk hosts of group with port of nearest group setting one, path holds groups being
+// expanded to detect cycles
+func (inv *Inventory) expand(group string, port int, path map[string]bool, add func(inventoryHost, int)) error {
+	if path[group] {
+		return fmt.Errorf("inventory group cycle detected at %s", group)
+	}
+	path[group] = true
+	defer delete(path, group)
+	// all:vars is the fallback of every host, not a port of group all
+	if p, ok := inv.groupPort[group]; ok && group != "all" {
+		port = p
+	}
+	// all contains every group unless defined explicitly
+	if group == "all" && len(inv.groups["all"]) == 0 && len(inv.children["all"]) == 0 {
+		for _, g := range inv.order {
+			if g == "all" {
+				continue
+			}
+			if err := inv.expand(g, port, path, add); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, h := range inv.groups[group] {
+		add(h, port)
+	}
+	for _, child := range inv.children[group] {
+		if err := inv.expand(child, port, path, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeepAliveMax missed keepalive replies before connection is taken as lost
+const DefaultKeepAliveMax = 3
+
+// keepAlive ping client every C.Server.KeepAlive and close it after KeepAliveMax pings in a row
+// got no reply in time. Returned stop ends pinging and tells whether connection was taken as lost
+func keepAlive(client *ssh.Client) (stop func() bool) {
+	interval := C.Server.KeepAlive
+	if interval <= 0 {
+		return func() bool { return false }
+	}
+	max := keepAliveMax()
+	var lost int32
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		missed := 0
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+			select {
+			case err := <-reply:
+				if err == nil {
+					missed = 0
+					continue
+				}
+			case <-time.After(interval):
+			case <-done:
+				return
+			}
+			if missed++; missed >= max {
+				atomic.StoreInt32(&lost, 1)
+				client.Close()
+				return
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		return atomic.LoadInt32(&lost) == 1
+	}
+}
+
+// keepAliveMax get configured missed keepalive limit or default
+func keepAliveMax() int {
+	if C.Server.KeepAliveMax > 0 {
+		return C.Server.KeepAliveMax
+	}
+	return DefaultKeepAliveMax
+}
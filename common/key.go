@@ -0,0 +1,72 @@
+package common
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// KeyPassphraseEnv env variable holding passphrase of encrypted private keys
+const KeyPassphraseEnv = "OPTOOL_KEY_PASSPHRASE"
+
+var (
+	signerLock  sync.Mutex
+	signerCache = make(map[string]ssh.Signer)
+)
+
+// loadSigner parse private key file, if key is encrypted passphrase is taken from
+// phrase, KeyPassphraseEnv or asked on terminal in order. Parsed keys are cached by path
+func loadSigner(keyPath, phrase string, plain bool) (ssh.Signer, error) {
+	signerLock.Lock()
+	defer signerLock.Unlock()
+	if signer, ok := signerCache[keyPath]; ok {
+		return signer, nil
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase []byte
+		if phrase != "" {
+			passphrase = []byte(phrase)
+			if !plain {
+				passphrase = Decrypt(phrase)
+			}
+		} else if passphrase, err = keyPassphrase(keyPath); err != nil {
+			return nil, fmt.Errorf("private key %s: %v", keyPath, err)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	}
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, fmt.Errorf("private key %s: wrong passphrase", keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("private key %s: %v", keyPath, err)
+	}
+	signerCache[keyPath] = signer
+	return signer, nil
+}
+
+// keyPassphrase get passphrase of encrypted key from env or terminal without echo
+func keyPassphrase(keyPath string) ([]byte, error) {
+	if p := os.Getenv(KeyPassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("key is encrypted, set private_key_phrase or %s", KeyPassphraseEnv)
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key %s: ", keyPath)
+	p, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return p, err
+}
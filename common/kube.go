@@ -0,0 +1,196 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKubectl kubectl used for pods, resolved by PATH
+const DefaultKubectl = "kubectl"
+
+// kubectl get configured kubectl or default
+func kubectl() string {
+	if C.Kubectl != "" {
+		return C.Kubectl
+	}
+	return DefaultKubectl
+}
+
+// ResolvePods pods of spec as namespace/pod hosts. spec is a label selector like app=web listing
+// running pods, or comma separated pod names where the ones without namespace get namespace.
+// Empty namespace is the current one of kubectl
+func ResolvePods(ctx context.Context, spec, namespace string) ([]string, error) {
+	if !strings.ContainsAny(spec, "=!") && !strings.Contains(spec, " in ") {
+		var pods []string
+		for _, p := range strings.Split(spec, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if !strings.Contains(p, "/") && namespace != "" {
+				p = namespace + "/" + p
+			}
+			pods = append(pods, p)
+		}
+		return pods, nil
+	}
+	args := []string{"get", "pods", "-l", spec, "--field-selector=status.phase=Running",
+		"-o", `jsonpath={range .items[*]}{.metadata.namespace}/{.metadata.name}{"\n"}{end}`}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, kubectl(), args...)
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list pods %s: %v %s", spec, err, strings.TrimSpace(stderr.String()))
+	}
+	pods := strings.Fields(string(out))
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no running pods match %s", spec)
+	}
+	return pods, nil
+}
+
+// splitPod namespace and name of host like namespace/pod, namespace is empty for plain pod
+func splitPod(host string) (namespace, pod string) {
+	if i := strings.Index(host, "/"); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return "", host
+}
+
+// RunPods run Cmd in every pod of Hosts by kubectl exec instead of ssh
+func (rc *RemoteCommand) RunPods() ([]HostResult, error) {
+	return rc.RunPodsContext(context.Background())
+}
+
+// RunPodsContext run Cmd in every pod of Hosts, which are namespace/pod or pod names, by kubectl
+// exec instead of ssh. Results are recorded like Start, so PrettyPrint and other outputs apply.
+// Env is exported in command. Pipe and stream modes, sudo, MaxOutputBytes and OnHostDone are not supported
+func (rc *RemoteCommand) RunPodsContext(ctx context.Context) ([]HostResult, error) {
+	if err := checkGzipLevel(); err != nil {
+		return nil, err
+	}
+	if rc.PipeMode || rc.Stream != nil || rc.Sudo {
+		return nil, errors.New("pods do not support pipe, stream or sudo")
+	}
+	if err := rc.checkBase64(); err != nil {
+		return nil, err
+	}
+	if err := rc.renderCommands(); err != nil {
+		return nil, err
+	}
+	prefix, err := exportPrefix(rc.Env)
+	if err != nil {
+		return nil, err
+	}
+	if rc.DryRun {
+		return rc.dryRun()
+	}
+	ctx, rc.cancel = context.WithCancel(ctx)
+	defer rc.cancel()
+	defer rc.startRunTimeout()()
+	rc.started = time.Now()
+	defer func() { rc.elapsed = time.Since(rc.started) }()
+	var sem chan struct{}
+	if C.Server.MaxParallel > 0 {
+		sem = make(chan struct{}, C.Server.MaxParallel)
+	}
+	wg := sync.WaitGroup{}
+	for i, batch := range rc.batches(rc.hostList()) {
+		rc.batchGap(ctx, i, batch)
+		for _, h := range batch {
+			wg.Add(1)
+			go func(h string) {
+				defer wg.Done()
+				if sem != nil {
+					select {
+					case sem <- struct{}{}:
+						defer func() { <-sem }()
+					case <-ctx.Done():
+					}
+				}
+				if ctx.Err() != nil {
+					rc.notAttempted(ctx, h)
+					return
+				}
+				defer rc.hostDone(h)
+				rc.execPod(ctx, h, prefix+rc.command(h))
+			}(h)
+		}
+		wg.Wait()
+	}
+	return rc.Result(), nil
+}
+
+// execPod run cmd in pod of host and record its result
+func (rc *RemoteCommand) execPod(ctx context.Context, host, cmd string) {
+	namespace, pod := splitPod(host)
+	args := []string{"exec"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	args = append(args, pod)
+	if rc.KubeContainer != "" {
+		args = append(args, "-c", rc.KubeContainer)
+	}
+	args = append(args, "--", "sh", "-c", cmd)
+	cctx := ctx
+	limit := rc.commandTimeout(host)
+	if limit > 0 {
+		var cancel context.CancelFunc
+		cctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+	stdout, stderr, merged := &bytes.Buffer{}, &bytes.Buffer{}, &lockedBuffer{}
+	var ow, ew io.Writer = stdout, stderr
+	if rc.MergeStderr {
+		ow, ew = merged, merged
+	}
+	c := exec.CommandContext(cctx, kubectl(), args...)
+	c.Stdout, c.Stderr = ow, ew
+	var timing HostTiming
+	ts := time.Now()
+	e := c.Run()
+	timing.Command = time.Since(ts)
+	o := stdout.Bytes()
+	if rc.MergeStderr {
+		o = merged.Bytes()
+	}
+	L.Debugf("RemoteCommand: [%s] kubectl exec cmd=%s, output=%d bytes, error=%v", host, cmd, len(o), e)
+	rc.storeOutput(host, string(o), stderr.String())
+	var err error
+	code := -1
+	var ee *exec.ExitError
+	switch {
+	case e == nil:
+		code = 0
+	case ctx.Err() != nil:
+		err = rc.canceled(ctx, host)
+	case cctx.Err() == context.DeadlineExceeded:
+		err = &ExecError{Host: host, ExitCode: -1, Err: &TimeoutError{Host: host, Timeout: limit}}
+	case errors.As(e, &ee) && ee.ExitCode() >= 0:
+		// kubectl exits with status of remote command
+		code = ee.ExitCode()
+		err = &ExecError{Host: host, ExitCode: code, Err: fmt.Errorf("Process exited with status %d", code)}
+	default:
+		err = &DialError{Host: host, Err: fmt.Errorf("kubectl exec: %v", e)}
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.Timing[host] = timing
+	if code >= 0 {
+		rc.ExitCode[host] = code
+	}
+	if err != nil {
+		rc.recordError(host, err)
+	}
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"io"
+	"sync"
+)
+
+// limitWriter keep first max bytes written and discard the rest, exceeded is called on overflow
+type limitWriter struct {
+	lock     sync.Mutex
+	w        io.Writer
+	left     int64
+	exceeded func()
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	n := len(p)
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+		l.exceeded()
+	}
+	l.left -= int64(len(p))
+	if _, err := l.w.Write(p); err != nil {
+		return 0, err
+	}
+	// report all written so copying goes on until session is closed
+	return n, nil
+}
+
+// limitReader read at most left bytes, exceeded is called if more follow
+type limitReader struct {
+	r        io.Reader
+	left     int64
+	exceeded func()
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		// peek one byte to tell overflow apart from output of exactly the limit
+		if n, err := l.r.Read(make([]byte, 1)); n == 0 && err != nil {
+			return 0, err
+		}
+		l.exceeded()
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"fmt"
+	"io"
+)
+
+// PrefixStream Stream callback printing each line as it arrives prefixed by its host, like
+// "web03 | Restarting service...". Hosts are padded to the longest name so lines align, stderr
+// lines go to we. A last line without newline is printed once the command exits
+func (rc *RemoteCommand) PrefixStream(wo, we io.Writer) func(host, line string, isErr bool) {
+	width := 0
+	for _, h := range rc.hostList() {
+		if len(h) > width {
+			width = len(h)
+		}
+	}
+	return func(host, line string, isErr bool) {
+		w := wo
+		if isErr {
+			w = we
+		}
+		fmt.Fprintf(w, "%s | %s\n", colorize(rc.Color, colorCyan, fmt.Sprintf("%-*s", width, host)), line)
+	}
+}
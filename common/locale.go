@@ -0,0 +1,33 @@
+package common
+
+import (
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultTermType terminal type of pty sessions if not configured
+const DefaultTermType = "xterm-256color"
+
+// termType terminal type of pty: term_type of config, then fallback
+func termType(fallback string) string {
+	if C.Server.TermType != "" {
+		return C.Server.TermType
+	}
+	return fallback
+}
+
+// setLocale send LANG of config or local LANG with pty session so remote tools get UTF-8 right.
+// sshd rejects it unless AcceptEnv allows LANG, which is not fatal
+func setLocale(sess *ssh.Session) {
+	lang := C.Server.Locale
+	if lang == "" {
+		lang = os.Getenv("LANG")
+	}
+	if lang == "" {
+		return
+	}
+	if err := sess.Setenv("LANG", lang); err != nil {
+		L.Debugf("setenv LANG=%s rejected, check AcceptEnv of sshd: %v", lang, err)
+	}
+}
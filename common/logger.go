@@ -0,0 +1,78 @@
+package common
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger receives internal diagnostics of the package
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// L package logger, discards everything until SetLogger is called
+var L Logger = nopLogger{}
+
+// SetLogger route package diagnostics to l, nil restores the no-op logger
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	L = l
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// Log levels of StdLogger
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// StdLogger Logger writing messages at or above Level to a standard *log.Logger
+type StdLogger struct {
+	Logger *log.Logger
+	Level  int
+}
+
+// NewStdLogger get logger writing to l messages at or above level
+func NewStdLogger(l *log.Logger, level int) *StdLogger {
+	return &StdLogger{Logger: l, Level: level}
+}
+
+func (s *StdLogger) logf(level int, prefix, format string, args ...interface{}) {
+	if level < s.Level {
+		return
+	}
+	s.Logger.Output(3, prefix+fmt.Sprintf(format, args...))
+}
+
+// Debugf log debug message
+func (s *StdLogger) Debugf(format string, args ...interface{}) {
+	s.logf(LevelDebug, "DEBUG ", format, args...)
+}
+
+// Infof log info message
+func (s *StdLogger) Infof(format string, args ...interface{}) {
+	s.logf(LevelInfo, "INFO ", format, args...)
+}
+
+// Warnf log warning message
+func (s *StdLogger) Warnf(format string, args ...interface{}) {
+	s.logf(LevelWarn, "WARN ", format, args...)
+}
+
+// Errorf log error message
+func (s *StdLogger) Errorf(format string, args ...interface{}) {
+	s.logf(LevelError, "ERROR ", format, args...)
+}
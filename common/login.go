@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Shell open interactive shell on the only host, see ShellContext
+func (rc *RemoteCommand) Shell() error {
+	return rc.ShellContext(context.Background())
+}
+
+// ShellContext open interactive shell on the only host with local terminal in raw mode like plain ssh,
+// using same auth and dial path as commands. Terminal is restored when shell exits.
+// Non-zero exit of shell is returned as *ExecError
+func (rc *RemoteCommand) ShellContext(ctx context.Context) error {
+	hosts := rc.hostList()
+	if len(hosts) != 1 {
+		return fmt.Errorf("interactive shell needs exactly one host, got %d", len(hosts))
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return fmt.Errorf("interactive shell needs stdin to be a terminal")
+	}
+	err := rc.run(ctx, func(ctx context.Context, ohost string, client *ssh.Client, timing *HostTiming, ready func()) {
+		if err := interactiveShell(client, fd, rc.ForwardX11); err != nil {
+			rc.setError(ohost, execError(ohost, err))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.errs[hosts[0]]
+}
+
+// interactiveShell run login shell of client wired to local terminal fd
+func interactiveShell(client *ssh.Client, fd int, x11 bool) error {
+	sess, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	if x11 {
+		if err = forwardX11(client, sess); err != nil {
+			return fmt.Errorf("x11 forwarding: %v", err)
+		}
+	}
+	cols, rows, err := terminal.GetSize(fd)
+	if err != nil {
+		cols, rows = 80, 24
+	}
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = DefaultTermType
+	}
+	term = termType(term)
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	setLocale(sess)
+	if err = sess.RequestPty(term, rows, cols, modes); err != nil {
+		return err
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	// deferred so terminal is restored on panic too
+	defer terminal.Restore(fd, state)
+	sess.Stdin, sess.Stdout, sess.Stderr = os.Stdin, os.Stdout, os.Stderr
+	stop := watchWindowSize(fd, sess)
+	defer stop()
+	if err = sess.Shell(); err != nil {
+		return err
+	}
+	return sess.Wait()
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics of runs, updated by every run of RemoteCommand in the process
+var (
+	metricRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "optool_runs_total",
+		Help: "Runs of remote commands.",
+	})
+	metricHosts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "optool_hosts_attempted_total",
+		Help: "Hosts a connection was attempted to.",
+	})
+	metricHostsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "optool_hosts_failed_total",
+		Help: "Hosts failed with an error or non-zero exit.",
+	})
+	metricHostsRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "optool_hosts_running",
+		Help: "Hosts being connected or running a command.",
+	})
+	metricConnErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "optool_connection_errors_total",
+		Help: "Hosts failed to connect, by type dial or auth.",
+	}, []string{"type"})
+	metricRunDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "optool_run_duration_seconds",
+		Help:    "Wall clock time of runs.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	})
+	metricsRegistry = prometheus.NewRegistry()
+)
+
+func init() {
+	metricsRegistry.MustRegister(metricCollectors()...)
+}
+
+// metricCollectors all collectors of run metrics
+func metricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{metricRuns, metricHosts, metricHostsFailed, metricHostsRunning, metricConnErrors, metricRunDuration}
+}
+
+// MetricsHandler http handler serving run metrics for prometheus to scrape
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// RegisterMetrics register run metrics to reg too, like prometheus.DefaultRegisterer
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range metricCollectors() {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeHost count attempted host once it finished by outcome
+func (rc *RemoteCommand) observeHost(ohost string) {
+	rc.lock.Lock()
+	err := rc.errs[ohost]
+	code := rc.ExitCode[ohost]
+	rc.lock.Unlock()
+	metricHostsRunning.Dec()
+	var dialErr *DialError
+	var authErr *AuthError
+	switch {
+	case errors.As(err, &dialErr):
+		metricConnErrors.WithLabelValues("dial").Inc()
+	case errors.As(err, &authErr):
+		metricConnErrors.WithLabelValues("auth").Inc()
+	}
+	if err != nil || code != 0 {
+		metricHostsFailed.Inc()
+	}
+}
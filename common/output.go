@@ -0,0 +1,245 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HostResult execution result of one host
+type HostResult struct {
+	Host     string     `json:"host" yaml:"host"`
+	Stdout   string     `json:"stdout" yaml:"stdout"`
+	Stderr   string     `json:"stderr" yaml:"stderr"`
+	ExitCode int        `json:"exit_code" yaml:"exit_code"` // -1 if command did not run or exit normally
+	Error    string     `json:"error" yaml:"error"`
+	Err      error      `json:"-" yaml:"-"` // error behind Error, nil if host succeeded
+	Timing   HostTiming `json:"-" yaml:"-"`
+}
+
+// Result results of all hosts in input or SortHosts order, gzip output is decompressed
+func (rc *RemoteCommand) Result() []HostResult {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	hosts := rc.printHosts()
+	results := make([]HostResult, 0, len(hosts))
+	for _, h := range hosts {
+		results = append(results, rc.result(h))
+	}
+	return results
+}
+
+// result result of host, lock must be held
+func (rc *RemoteCommand) result(h string) HostResult {
+	r := HostResult{
+		Host:     h,
+		Stderr:   rc.Stderr[h],
+		ExitCode: -1,
+		Error:    rc.Error[h],
+		Err:      rc.errs[h],
+		Timing:   rc.Timing[h],
+	}
+	if code, ok := rc.ExitCode[h]; ok {
+		r.ExitCode = code
+	}
+	if o, ok := rc.Output[h]; ok {
+		stdout, err := rc.decode(o)
+		if err != nil && r.Error == "" {
+			r.Error, r.Err = err.Error(), err
+		}
+		r.Stdout = stdout
+	}
+	return r
+}
+
+// JSONPrint print results as json array
+func (rc *RemoteCommand) JSONPrint(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rc.Result())
+}
+
+// CSVPrint print results as csv with header row, quoted per RFC 4180. comma is the
+// field separator, '\t' for tsv. valueOnly print host and output columns only.
+// trailing newlines of output are trimmed
+func (rc *RemoteCommand) CSVPrint(w io.Writer, comma rune, valueOnly bool) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := []string{"host", "exit_code", "output", "error"}
+	if valueOnly {
+		header = []string{"host", "output"}
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rc.Result() {
+		output := strings.TrimRight(r.Stdout, "\n")
+		record := []string{r.Host, strconv.Itoa(r.ExitCode), output, r.Error}
+		if valueOnly {
+			record = []string{r.Host, output}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// decodeOutput decompress output if gzip is enabled
+func decodeOutput(o string) (string, error) {
+	if !C.Gzip || o == "" {
+		return o, nil
+	}
+	gr, err := gzipReader(strings.NewReader(o))
+	if err != nil {
+		return "", err
+	}
+	defer gr.Close()
+	data, err := ioutil.ReadAll(gr)
+	return string(data), err
+}
+
+// decode get printable output: decompressed if gzip is enabled, escapes removed if StripANSI is set,
+// secrets masked and lines filtered by OutputFilter, then cut to HeadLines and TailLines
+func (rc *RemoteCommand) decode(o string) (string, error) {
+	if rc.DryRun {
+		return o, nil
+	}
+	o, err := decodeOutput(o)
+	if rc.Base64 {
+		// binary output is kept as is
+		return o, err
+	}
+	if rc.StripANSI {
+		o = stripANSI(o)
+	}
+	if C.Gzip {
+		// plain output is redacted and filtered when stored
+		o = rc.filterOutput(rc.redact(o))
+	}
+	o = rc.truncateLines(o)
+	return o, err
+}
+
+// OutputGroup hosts having identical output
+type OutputGroup struct {
+	Output string
+	Hosts  []string
+}
+
+// groupOutputs bucket hosts by trimmed value, biggest group first, ties in host order.
+// values are passed through decode if not nil
+func groupOutputs(hosts []string, values map[string]string, decode func(string) (string, error)) []OutputGroup {
+	var groups []OutputGroup
+	index := make(map[string]int)
+	for _, h := range hosts {
+		v, ok := values[h]
+		if !ok {
+			continue
+		}
+		if decode != nil {
+			d, err := decode(v)
+			if err != nil {
+				d = "decode output: " + err.Error()
+			}
+			v = d
+		}
+		v = strings.TrimRight(v, "\n")
+		i, ok := index[v]
+		if !ok {
+			i = len(groups)
+			index[v] = i
+			groups = append(groups, OutputGroup{Output: v})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, h)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+	return groups
+}
+
+// GroupedOutput hosts grouped by identical decompressed output
+func (rc *RemoteCommand) GroupedOutput() []OutputGroup {
+	return groupOutputs(rc.printHosts(), rc.Output, rc.decode)
+}
+
+// GroupedError hosts grouped by identical error
+func (rc *RemoteCommand) GroupedError() []OutputGroup {
+	return groupOutputs(rc.printHosts(), rc.Error, nil)
+}
+
+// PrettyPrintGrouped print each unique output/error once with hosts producing it
+func (rc *RemoteCommand) PrettyPrintGrouped(wo io.Writer, we io.Writer, noHeader bool) {
+	if groups := rc.GroupedError(); len(groups) > 0 {
+		if !noHeader {
+			fmt.Fprintln(we, "================================= ERROR =================================")
+		}
+		printGroups(we, groups)
+	}
+	if rc.ErrorsOnly {
+		return
+	}
+	groups := rc.GroupedOutput()
+	if rc.DiffersOnly && len(groups) > 0 {
+		groups = groups[1:]
+	}
+	if len(groups) > 0 {
+		if !noHeader {
+			fmt.Fprintln(wo, "================================= OUTPUT =================================")
+		}
+		printGroups(wo, groups)
+	}
+}
+
+func printGroups(w io.Writer, groups []OutputGroup) {
+	for _, g := range groups {
+		fmt.Fprintf(w, "----- %d hosts: %s\n", len(g.Hosts), strings.Join(g.Hosts, ","))
+		fmt.Fprintln(w, g.Output)
+	}
+}
+
+// WriteOutputDir write output of every host to <dir>/<host>.out and stderr/error to <host>.err,
+// and a manifest listing exit codes and sizes. keepGzip saves gzip output as <host>.out.gz as is
+func (rc *RemoteCommand) WriteOutputDir(dir string, keepGzip bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	manifest := &bytes.Buffer{}
+	fmt.Fprintln(manifest, "host\texit_code\tstdout_bytes\tstderr_bytes\terror")
+	for _, r := range rc.Result() {
+		name := filepath.Join(dir, hostFileName(r.Host))
+		stdout := []byte(r.Stdout)
+		outFile := name + ".out"
+		if keepGzip && C.Gzip && !rc.DryRun {
+			stdout = []byte(rc.Output[r.Host])
+			outFile += ".gz"
+		}
+		if err := ioutil.WriteFile(outFile, stdout, 0644); err != nil {
+			return err
+		}
+		stderr := r.Stderr
+		if r.Error != "" {
+			stderr += r.Error + "\n"
+		}
+		if err := ioutil.WriteFile(name+".err", []byte(stderr), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(manifest, "%s\t%d\t%d\t%d\t%s\n", r.Host, r.ExitCode, len(stdout), len(r.Stderr), strings.Replace(r.Error, "\n", " ", -1))
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "manifest.txt"), manifest.Bytes(), 0644)
+}
+
+// hostFileName host as file name, port and IPv6 colons are replaced
+func hostFileName(host string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_", "[", "", "]", "").Replace(host)
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PasswordEnv env variable holding password used if neither password nor password_file is set
+const PasswordEnv = "OPTOOL_PASSWORD"
+
+// promptedPasswords passwords asked on terminal by user, guarded by promptLock
+var promptedPasswords = make(map[string]string)
+
+// AuthPassword password of a from password, password_file or PasswordEnv in order, empty if
+// none is set. the file and env hold plain passwords, a trailing newline is dropped
+func AuthPassword(a *AuthConfig) (string, error) {
+	if a.Password != "" {
+		if a.PlainPassword {
+			return a.Password, nil
+		}
+		return string(Decrypt(a.Password)), nil
+	}
+	if a.PasswordFile != "" {
+		b, err := ioutil.ReadFile(expandHome(a.PasswordFile))
+		if err != nil {
+			return "", fmt.Errorf("password_file: %v", err)
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+	}
+	return os.Getenv(PasswordEnv), nil
+}
+
+// promptPassword password auth asking on terminal without echo once the server wants a
+// password, answered once for all hosts of user. nil if stdin is not a terminal
+func promptPassword(user string) ssh.AuthMethod {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil
+	}
+	return ssh.PasswordCallback(func() (string, error) {
+		promptLock.Lock()
+		defer promptLock.Unlock()
+		if p, ok := promptedPasswords[user]; ok {
+			return p, nil
+		}
+		fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+		p, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		promptedPasswords[user] = string(p)
+		return string(p), nil
+	})
+}
+
+// sudoPassword password for sudo of login user, SudoPassword or else the login password
+// asked on terminal
+func (rc *RemoteCommand) sudoPassword(user string) string {
+	if rc.SudoPassword != "" {
+		return rc.SudoPassword
+	}
+	promptLock.Lock()
+	defer promptLock.Unlock()
+	return promptedPasswords[user]
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// PlanEntry command of one host in a plan
+type PlanEntry struct {
+	Host    string            `json:"host"`
+	Cmd     string            `json:"cmd"`
+	Env     map[string]string `json:"env,omitempty"`     // exported before cmd
+	WorkDir string            `json:"workdir,omitempty"` // cmd runs there, host fails if missing
+}
+
+// ReadPlan parse json plan, either a list of PlanEntry run in list order, or an object
+// mapping hosts to their entries without host, run in host name order
+func ReadPlan(r io.Reader) ([]PlanEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var plan []PlanEntry
+	if len(data) > 0 && data[0] == '{' {
+		var byHost map[string]PlanEntry
+		if err = json.Unmarshal(data, &byHost); err != nil {
+			return nil, fmt.Errorf("plan: %v", err)
+		}
+		for h, e := range byHost {
+			e.Host = h
+			plan = append(plan, e)
+		}
+		sort.Slice(plan, func(i, j int) bool { return plan[i].Host < plan[j].Host })
+	} else if err = json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("plan: %v", err)
+	}
+	if len(plan) == 0 {
+		return nil, errors.New("plan: no hosts")
+	}
+	seen := make(map[string]bool, len(plan))
+	for _, e := range plan {
+		switch {
+		case e.Host == "":
+			return nil, errors.New("plan: entry without host")
+		case e.Cmd == "":
+			return nil, fmt.Errorf("plan: no cmd for %s", e.Host)
+		case seen[e.Host]:
+			return nil, fmt.Errorf("plan: duplicate host %s", e.Host)
+		}
+		seen[e.Host] = true
+	}
+	return plan, nil
+}
+
+// PlanHosts hosts of plan in order
+func PlanHosts(plan []PlanEntry) []string {
+	hosts := make([]string, 0, len(plan))
+	for _, e := range plan {
+		hosts = append(hosts, e.Host)
+	}
+	return hosts
+}
+
+// NewRemoteCommandPlan prepare a remote execution running the own command of every host of plan,
+// results are keyed by host like with a shared command
+func NewRemoteCommandPlan(plan []PlanEntry) (*RemoteCommand, error) {
+	rc := NewRemoteCommand(PlanHosts(plan), "")
+	rc.Commands = make(map[string]string, len(plan))
+	for _, e := range plan {
+		prefix, err := exportPrefix(e.Env)
+		if err != nil {
+			return nil, fmt.Errorf("plan of %s: %v", e.Host, err)
+		}
+		cmd := prefix + e.Cmd
+		if C.Gzip {
+			cmd = gzipCommand(cmd)
+		}
+		rc.Commands[e.Host] = cmd
+		if e.WorkDir != "" {
+			if rc.WorkDirs == nil {
+				rc.WorkDirs = make(map[string]string)
+			}
+			rc.WorkDirs[e.Host] = e.WorkDir
+		}
+	}
+	return rc, nil
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultPoolIdle idle time after which pooled connections are closed
+const DefaultPoolIdle = 5 * time.Minute
+
+// Pool keeps ssh connections open to reuse them across runs of RemoteCommand sharing it,
+// saving dial and auth of every run. Connections idle longer than Idle are closed
+type Pool struct {
+	Idle time.Duration
+
+	lock  sync.Mutex
+	conns map[string]*pooledConn
+}
+
+// pooledConn connection cached by Pool, it is idle once refs drops to 0
+type pooledConn struct {
+	client *ssh.Client
+	banner string
+	refs   int
+	timer  *time.Timer
+}
+
+// NewPool create connection pool, idle<=0 means DefaultPoolIdle
+func NewPool(idle time.Duration) *Pool {
+	if idle <= 0 {
+		idle = DefaultPoolIdle
+	}
+	return &Pool{Idle: idle, conns: make(map[string]*pooledConn)}
+}
+
+// acquire get cached connection of key still alive and its banner, nil if none
+func (p *Pool) acquire(key string) (*ssh.Client, string) {
+	p.lock.Lock()
+	pc, ok := p.conns[key]
+	if ok {
+		pc.refs++
+		if pc.timer != nil {
+			pc.timer.Stop()
+			pc.timer = nil
+		}
+	}
+	p.lock.Unlock()
+	if !ok {
+		return nil, ""
+	}
+	if !alive(pc.client) {
+		L.Debugf("pool: drop dead connection %s", key)
+		p.release(key, pc.client, true)
+		return nil, ""
+	}
+	L.Debugf("pool: reuse connection %s", key)
+	return pc.client, pc.banner
+}
+
+// add cache new connection of key as in use
+func (p *Pool) add(key string, client *ssh.Client, banner string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.conns[key]; ok {
+		// raced dial of the same host, this one is closed on release
+		return
+	}
+	p.conns[key] = &pooledConn{client: client, banner: banner, refs: 1}
+}
+
+// release give connection back, broken connections are closed at once and idle ones after Idle
+func (p *Pool) release(key string, client *ssh.Client, broken bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pc, ok := p.conns[key]
+	if !ok || pc.client != client {
+		client.Close()
+		return
+	}
+	if broken {
+		delete(p.conns, key)
+		client.Close()
+		return
+	}
+	if pc.refs--; pc.refs > 0 {
+		return
+	}
+	pc.timer = time.AfterFunc(p.Idle, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if cur, ok := p.conns[key]; ok && cur == pc && pc.refs == 0 {
+			L.Debugf("pool: close idle connection %s", key)
+			delete(p.conns, key)
+			pc.client.Close()
+		}
+	})
+}
+
+// CloseAll close every pooled connection, in use ones included. Pool stays usable
+func (p *Pool) CloseAll() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, pc := range p.conns {
+		if pc.timer != nil {
+			pc.timer.Stop()
+		}
+		pc.client.Close()
+		delete(p.conns, key)
+	}
+}
+
+// alive check connection answers a keepalive in time
+func alive(client *ssh.Client) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(connectTimeout()):
+		return false
+	}
+}
+
+var (
+	handlerLock sync.Mutex
+	// handlers state of channel handlers by connection and channel type. ssh allows one
+	// handler per channel type on a connection, so connections reused by Pool share them
+	handlers = make(map[*ssh.Client]map[string]interface{})
+)
+
+// channelHandler state of handler of channel type on client, set up by register on first use.
+// The state is dropped once client is closed
+func channelHandler(client *ssh.Client, kind string, register func() (interface{}, error)) (interface{}, error) {
+	handlerLock.Lock()
+	defer handlerLock.Unlock()
+	kinds, ok := handlers[client]
+	if !ok {
+		kinds = make(map[string]interface{})
+		handlers[client] = kinds
+		go func() {
+			client.Wait()
+			handlerLock.Lock()
+			delete(handlers, client)
+			handlerLock.Unlock()
+		}()
+	}
+	if state, ok := kinds[kind]; ok {
+		return state, nil
+	}
+	state, err := register()
+	if err != nil {
+		return nil, err
+	}
+	kinds[kind] = state
+	return state, nil
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Progress renders a "237/5000 complete (12 failed)" line as hosts finish,
+// rewritten in place so it suits a terminal only
+type Progress struct {
+	w      io.Writer
+	total  int
+	done   int
+	failed int
+	lock   sync.Mutex
+}
+
+// NewProgress progress of total hosts drawn on f, nil if f is not a terminal
+func NewProgress(f *os.File, total int) *Progress {
+	if !terminal.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	return &Progress{w: f, total: total}
+}
+
+// Done count finished host and redraw, fits OnHostDone
+func (p *Progress) Done(host string, result HostResult) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.done++
+	if result.Err != nil || result.ExitCode != 0 {
+		p.failed++
+	}
+	fmt.Fprint(p.w, "\r\x1b[K")
+	p.draw()
+}
+
+// Printf print a line above progress line and redraw it
+func (p *Progress) Printf(format string, a ...interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fmt.Fprint(p.w, "\r\x1b[K")
+	fmt.Fprintf(p.w, format, a...)
+	if p.done > 0 {
+		p.draw()
+	}
+}
+
+// draw write progress line, caller holds lock
+func (p *Progress) draw() {
+	fmt.Fprintf(p.w, "%d/%d complete (%d failed)", p.done, p.total, p.failed)
+}
+
+// Finish erase progress line so following output starts clean
+func (p *Progress) Finish() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.done > 0 {
+		fmt.Fprint(p.w, "\r\x1b[K")
+	}
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialTCP connect addr directly or through socks5 proxy if configured, timeout covers proxy handshake
+func dialTCP(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if C.Server.Socks5 == "" {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	var auth *proxy.Auth
+	if C.Server.Socks5User != "" {
+		password := C.Server.Socks5Password
+		if !C.Auth.PlainPassword {
+			password = string(Decrypt(password))
+		}
+		auth = &proxy.Auth{User: C.Server.Socks5User, Password: password}
+	}
+	pd, err := proxy.SOCKS5("tcp", C.Server.Socks5, auth, d)
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := pd.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("socks5 dialer does not support context")
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return cd.DialContext(ctx, "tcp", addr)
+}
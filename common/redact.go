@@ -0,0 +1,55 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactMask replaces redacted secrets
+const redactMask = "***"
+
+// RedactPatterns compile regexps and literal secret strings for Redact
+func RedactPatterns(regexps, literals []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range regexps {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	for _, l := range literals {
+		if l != "" {
+			res = append(res, regexp.MustCompile(regexp.QuoteMeta(l)))
+		}
+	}
+	return res, nil
+}
+
+// redact mask matches of Redact in s. patterns with groups mask only the groups,
+// so password=(\S+) keeps password=
+func (rc *RemoteCommand) redact(s string) string {
+	for _, re := range rc.Redact {
+		if re.NumSubexp() == 0 {
+			s = re.ReplaceAllLiteralString(s, redactMask)
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+			for i := 2; i < len(m); i += 2 {
+				if m[i] < last || m[i+1] <= m[i] {
+					// group not matched, empty or nested in previous one
+					continue
+				}
+				b.WriteString(s[last:m[i]])
+				b.WriteString(redactMask)
+				last = m[i+1]
+			}
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s
+}
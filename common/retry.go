@@ -0,0 +1,86 @@
+package common
+
+// ErrorHosts hosts that failed with an error or exited non-zero, in input order
+func (rc *RemoteCommand) ErrorHosts() []string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	var hosts []string
+	for _, h := range rc.hostList() {
+		_, failed := rc.Error[h]
+		if code, ok := rc.ExitCode[h]; failed || ok && code != 0 {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// RetryFailed fresh command with same Cmd and options targeting only ErrorHosts, nil if
+// every host succeeded. Results of rc are left untouched. Start reruns Cmd, runs of
+// RunScript or RunCommands are repeated by calling them again on the new command
+func (rc *RemoteCommand) RetryFailed() *RemoteCommand {
+	hosts := rc.ErrorHosts()
+	if len(hosts) == 0 {
+		return nil
+	}
+	return rc.withHosts(hosts)
+}
+
+// withHosts fresh command with same Cmd and options targeting hosts
+func (rc *RemoteCommand) withHosts(hosts []string) *RemoteCommand {
+	n := NewRemoteCommand(hosts, "")
+	// Cmd is already wrapped for gzip
+	n.Cmd = rc.Cmd
+	n.stdin = rc.stdin
+	n.Commands = rc.Commands
+	n.Template = rc.Template
+	n.HostVars = rc.HostVars
+	n.StripANSI = rc.StripANSI
+	n.MergeStderr = rc.MergeStderr
+	n.Base64 = rc.Base64
+	n.ShowBanner = rc.ShowBanner
+	n.PrintSummary = rc.PrintSummary
+	n.OnHostDone = rc.OnHostDone
+	n.ForwardAgent = rc.ForwardAgent
+	n.ForwardX11 = rc.ForwardX11
+	n.MaxOutputBytes = rc.MaxOutputBytes
+	n.ContinueOnError = rc.ContinueOnError
+	n.DryRun = rc.DryRun
+	n.PipeMode = rc.PipeMode
+	n.Color = rc.Color
+	n.ErrorsOnly = rc.ErrorsOnly
+	n.FailedOnly = rc.FailedOnly
+	n.DiffersOnly = rc.DiffersOnly
+	n.MarkEmpty = rc.MarkEmpty
+	n.SortHosts = rc.SortHosts
+	n.OutputFilter = rc.OutputFilter
+	n.FilterInvert = rc.FilterInvert
+	n.FilterCount = rc.FilterCount
+	n.Redact = rc.Redact
+	n.HeadLines, n.TailLines = rc.HeadLines, rc.TailLines
+	n.Stream = rc.Stream
+	n.RequestPty = rc.RequestPty
+	n.PtyTerm, n.PtyRows, n.PtyCols = rc.PtyTerm, rc.PtyRows, rc.PtyCols
+	n.KubeContainer = rc.KubeContainer
+	n.WorkDir = rc.WorkDir
+	n.WorkDirs = rc.WorkDirs
+	n.Env = rc.Env
+	n.EnvExport = rc.EnvExport
+	n.Sudo = rc.Sudo
+	n.SudoPassword = rc.SudoPassword
+	n.MaxFailures = rc.MaxFailures
+	n.FailFast = rc.FailFast
+	n.Serial, n.SerialDelay, n.SerialPause = rc.Serial, rc.SerialDelay, rc.SerialPause
+	n.BatchSize, n.BatchDelay, n.BatchPause = rc.BatchSize, rc.BatchDelay, rc.BatchPause
+	n.CommandTimeout = rc.CommandTimeout
+	n.CommandTimeouts = rc.CommandTimeouts
+	n.RunTimeout = rc.RunTimeout
+	n.VerifyChecksum = rc.VerifyChecksum
+	n.BackupUpload = rc.BackupUpload
+	n.NoReplace = rc.NoReplace
+	n.BandwidthLimit = rc.BandwidthLimit
+	n.HostBandwidthLimit = rc.HostBandwidthLimit
+	n.Pool = rc.Pool
+	n.RetryAttempts = rc.RetryAttempts
+	n.RetryBackoff = rc.RetryBackoff
+	return n
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// scriptShells interpreters reading script from stdin by -s
+var scriptShells = map[string]bool{"sh": true, "bash": true, "dash": true, "ksh": true, "zsh": true}
+
+// RunScript run local script file on every host, see RunScriptContext
+func (rc *RemoteCommand) RunScript(path string, args ...string) ([]HostResult, error) {
+	return rc.RunScriptContext(context.Background(), path, args...)
+}
+
+// RunScriptContext run local script file on every host by feeding it to stdin of remote shell,
+// args are passed to script as $1.. . Shell is taken from shebang if it is a sh-like shell, sh otherwise
+func (rc *RemoteCommand) RunScriptContext(ctx context.Context, path string, args ...string) ([]HostResult, error) {
+	script, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cmd := scriptShell(script) + " -s --"
+	for _, a := range args {
+		cmd += " " + shellQuote(a)
+	}
+	if C.Gzip {
+		cmd = gzipCommand(cmd)
+	}
+	rc.Cmd = cmd
+	rc.stdin = script
+	return rc.StartContext(ctx)
+}
+
+// scriptShell get shell of shebang line like #!/bin/bash or #!/usr/bin/env bash
+func scriptShell(script []byte) string {
+	if !bytes.HasPrefix(script, []byte("#!")) {
+		return "sh"
+	}
+	line := string(script[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "sh"
+	}
+	shell := fields[0]
+	if filepath.Base(shell) == "env" && len(fields) > 1 {
+		shell = fields[1]
+	}
+	if !scriptShells[filepath.Base(shell)] {
+		return "sh"
+	}
+	return shell
+}
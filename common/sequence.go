@@ -0,0 +1,148 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// StepResult result of one command of a sequence on a host
+type StepResult struct {
+	Cmd      string `json:"cmd"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// ReadCommands read one command per line, empty lines and lines starting with # are skipped
+func ReadCommands(r io.Reader) ([]string, error) {
+	var cmds []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cmds = append(cmds, line)
+	}
+	return cmds, scanner.Err()
+}
+
+// RunCommands run commands in order on every host, see RunCommandsContext
+func (rc *RemoteCommand) RunCommands(cmds []string) ([]HostResult, error) {
+	return rc.RunCommandsContext(context.Background(), cmds)
+}
+
+// RunCommandsContext run commands one by one in a single shell per host, so cd and variables
+// carry over. Remaining commands of a host are skipped after the first failure unless
+// ContinueOnError is set. Output of each command is kept in Steps by host and command index
+func (rc *RemoteCommand) RunCommandsContext(ctx context.Context, cmds []string) ([]HostResult, error) {
+	if rc.PipeMode || rc.Stream != nil {
+		return nil, errors.New("command sequence is not supported in pipe or stream mode")
+	}
+	if C.Gzip {
+		return nil, errors.New("command sequence is not supported with gzip")
+	}
+	if len(cmds) == 0 {
+		return nil, errors.New("no commands to run")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	marker := "__OPTOOL_STEP_" + hex.EncodeToString(b)
+	rc.Cmd = "sh -c " + shellQuote(sequenceScript(cmds, marker, rc.ContinueOnError))
+	// markers must survive OutputFilter until steps are split
+	rc.stepMarker = marker
+	results, err := rc.StartContext(ctx)
+	rc.stepMarker = ""
+	if err != nil || rc.DryRun {
+		return results, err
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.Steps = make(map[string][]StepResult)
+	for i, r := range results {
+		// split stored output, results have it filtered and truncated already
+		stdout, outSteps := splitSteps(rc.Output[r.Host], marker, "o")
+		stderr, _ := splitSteps(rc.Stderr[r.Host], marker, "e")
+		steps := make([]StepResult, len(outSteps))
+		for j, s := range outSteps {
+			steps[j] = StepResult{Cmd: cmds[j], Stdout: rc.filterOutput(s.text), ExitCode: s.code}
+			if j < len(stderr) {
+				steps[j].Stderr = stderr[j]
+			}
+		}
+		rc.Steps[r.Host] = steps
+		if _, ok := rc.Output[r.Host]; ok {
+			rc.Output[r.Host] = rc.filterOutput(strings.Join(stdout, ""))
+			rc.Stderr[r.Host] = strings.Join(stderr, "")
+		}
+		results[i] = rc.result(r.Host)
+	}
+	return results, nil
+}
+
+// sequenceScript shell script running cmds in current shell, marking end of each on stdout and stderr
+func sequenceScript(cmds []string, marker string, continueOnError bool) string {
+	var b strings.Builder
+	b.WriteString("__optool_fail=0\n")
+	for i, cmd := range cmds {
+		// stdin is closed so commands can not read from the script
+		fmt.Fprintf(&b, "{ %s\n} </dev/null\n__optool_rc=$?\n", cmd)
+		fmt.Fprintf(&b, "printf '%s o %d %%d\\n' $__optool_rc\n", marker, i)
+		fmt.Fprintf(&b, "printf '%s e %d %%d\\n' $__optool_rc >&2\n", marker, i)
+		if continueOnError {
+			b.WriteString("[ $__optool_rc -eq 0 ] || __optool_fail=$__optool_rc\n")
+		} else {
+			b.WriteString("[ $__optool_rc -eq 0 ] || exit $__optool_rc\n")
+		}
+	}
+	b.WriteString("exit $__optool_fail\n")
+	return b.String()
+}
+
+type step struct {
+	text string
+	code int
+}
+
+// splitSteps split output by end markers of channel, get text of every step with leftover after
+// last marker as an extra one, and finished steps with exit codes. Markers of other channel are dropped
+func splitSteps(out, marker, channel string) ([]string, []step) {
+	var texts []string
+	var steps []step
+	var cur strings.Builder
+	for len(out) > 0 {
+		line := out
+		if i := strings.IndexByte(out, '\n'); i >= 0 {
+			line, out = out[:i+1], out[i+1:]
+		} else {
+			out = ""
+		}
+		i := strings.Index(line, marker+" ")
+		if i < 0 {
+			cur.WriteString(line)
+			continue
+		}
+		cur.WriteString(line[:i])
+		fields := strings.Fields(line[i+len(marker):])
+		if len(fields) != 3 || fields[0] != channel {
+			continue
+		}
+		code, _ := strconv.Atoi(fields[2])
+		texts = append(texts, cur.String())
+		steps = append(steps, step{text: cur.String(), code: code})
+		cur.Reset()
+	}
+	if cur.Len() > 0 {
+		texts = append(texts, cur.String())
+	}
+	return texts, steps
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envNamePattern valid environment variable name
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// shellQuote quote s as a single word for posix shell
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// QuoteCommand build command line running name with args, each quoted as a single word
+// so untrusted values like file names are never interpreted by remote shell
+func QuoteCommand(name string, args ...string) string {
+	words := make([]string, 0, len(args)+1)
+	for _, w := range append([]string{name}, args...) {
+		words = append(words, shellQuote(w))
+	}
+	return strings.Join(words, " ")
+}
+
+// exportPrefix build "export K='v'; " for env, keys are sorted
+func exportPrefix(env map[string]string) (string, error) {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if !envNamePattern.MatchString(k) {
+			return "", fmt.Errorf("invalid env name: %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString("export " + k + "=" + shellQuote(env[k]) + "; ")
+	}
+	return b.String(), nil
+}
+
+// sudoCommand wrap cmd to run by sudo, password is the first line of stdin if hasPassword.
+// The wrapper shell consumes that line and checks it by sudo -v, so it is never read by cmd
+// even when sudo does not ask (NOPASSWD or cached). closeStdin gives cmd no stdin at all
+func sudoCommand(cmd string, hasPassword, closeStdin bool) string {
+	run := "sudo -n -- sh -c " + shellQuote(cmd)
+	if !hasPassword {
+		return run
+	}
+	if closeStdin {
+		run += " </dev/null"
+	}
+	return `IFS= read -r p || exit 1; printf '%s\n' "$p" | sudo -S -p '' -v || exit 1; unset p; exec ` + run
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// SortInput print hosts in input order
+	SortInput = ""
+	// SortLexical print hosts sorted as strings, web10 before web2
+	SortLexical = "lexical"
+	// SortNatural print hosts sorted with numbers compared by value, web2 before web10
+	SortNatural = "natural"
+)
+
+// sortHosts copy of hosts in SortHosts order
+func (rc *RemoteCommand) sortHosts(hosts []string) []string {
+	if rc.SortHosts == SortInput {
+		return hosts
+	}
+	sorted := append([]string{}, hosts...)
+	less := func(i, j int) bool { return sorted[i] < sorted[j] }
+	if rc.SortHosts == SortNatural {
+		less = func(i, j int) bool { return naturalLess(sorted[i], sorted[j]) }
+	}
+	sort.SliceStable(sorted, less)
+	return sorted
+}
+
+// printHosts unique hosts in SortHosts order
+func (rc *RemoteCommand) printHosts() []string {
+	return rc.sortHosts(rc.hostList())
+}
+
+// naturalLess compare strings with digit runs compared by value, so 10.0.0.9 < 10.0.0.10.
+// numbers equal in value like 01 and 1 fall back to string order
+func naturalLess(a, b string) bool {
+	x, y := a, b
+	for x != "" && y != "" {
+		cx, cy := x[0], y[0]
+		if isDigit(cx) && isDigit(cy) {
+			nx, ny := digits(x), digits(y)
+			tx, ty := strings.TrimLeft(x[:nx], "0"), strings.TrimLeft(y[:ny], "0")
+			if len(tx) != len(ty) {
+				return len(tx) < len(ty)
+			}
+			if tx != ty {
+				return tx < ty
+			}
+			x, y = x[nx:], y[ny:]
+			continue
+		}
+		if cx != cy {
+			return cx < cy
+		}
+		x, y = x[1:], y[1:]
+	}
+	if len(x) != len(y) {
+		return len(x) < len(y)
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digits length of leading digit run of s
+func digits(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshHostConfig options of ssh config applied to a host
+type sshHostConfig struct {
+	HostName      string
+	Port          int
+	User          string
+	IdentityFiles []string
+}
+
+// sshConfigStanza a Host section of ssh config
+type sshConfigStanza struct {
+	patterns []string
+	options  sshHostConfig
+}
+
+var (
+	sshConfigLock    sync.Mutex
+	sshConfigStanzas []sshConfigStanza
+)
+
+// ParseSSHConfig parse ssh config file, only HostName/Port/User/IdentityFile are used
+func ParseSSHConfig(f string) error {
+	fp, err := os.Open(expandHome(f))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	// options before first Host apply to all hosts
+	stanzas := []sshConfigStanza{{patterns: []string{"*"}}}
+	cur := &stanzas[0]
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value := splitSSHConfigLine(line)
+		switch strings.ToLower(key) {
+		case "host":
+			stanzas = append(stanzas, sshConfigStanza{patterns: strings.Fields(value)})
+			cur = &stanzas[len(stanzas)-1]
+		case "match":
+			// Match conditions are not supported, never match
+			stanzas = append(stanzas, sshConfigStanza{})
+			cur = &stanzas[len(stanzas)-1]
+		case "hostname":
+			if cur.options.HostName == "" {
+				cur.options.HostName = value
+			}
+		case "port":
+			if cur.options.Port == 0 {
+				cur.options.Port, _ = strconv.Atoi(value)
+			}
+		case "user":
+			if cur.options.User == "" {
+				cur.options.User = value
+			}
+		case "identityfile":
+			cur.options.IdentityFiles = append(cur.options.IdentityFiles, expandHome(value))
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	sshConfigLock.Lock()
+	sshConfigStanzas = stanzas
+	sshConfigLock.Unlock()
+	return nil
+}
+
+// splitSSHConfigLine split "Key value" or "Key=value", quotes are removed
+func splitSSHConfigLine(line string) (key, value string) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, ""
+	}
+	key = line[:i]
+	value = strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	value = strings.Trim(value, `"`)
+	return
+}
+
+// match check whether host matches Host patterns, negated pattern excludes host
+func (s *sshConfigStanza) match(host string) bool {
+	matched := false
+	for _, p := range s.patterns {
+		if strings.HasPrefix(p, "!") {
+			if ok, _ := path.Match(p[1:], host); ok {
+				return false
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, host); ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// lookupSSHConfig merge options of all matching stanzas, first obtained value wins
+func lookupSSHConfig(host string) (hc sshHostConfig, ok bool) {
+	sshConfigLock.Lock()
+	defer sshConfigLock.Unlock()
+	for i := range sshConfigStanzas {
+		s := &sshConfigStanzas[i]
+		if !s.match(host) {
+			continue
+		}
+		o := s.options
+		if o.HostName == "" && o.Port == 0 && o.User == "" && len(o.IdentityFiles) == 0 {
+			continue
+		}
+		ok = true
+		if hc.HostName == "" {
+			hc.HostName = strings.Replace(o.HostName, "%h", host, -1)
+		}
+		if hc.Port == 0 {
+			hc.Port = o.Port
+		}
+		if hc.User == "" {
+			hc.User = o.User
+		}
+		hc.IdentityFiles = append(hc.IdentityFiles, o.IdentityFiles...)
+	}
+	return
+}
+
+// applySSHConfig resolve host alias by ssh config, get address and client config to dial
+func applySSHConfig(host string, cfg *ssh.ClientConfig) (string, *ssh.ClientConfig, error) {
+	// host with port given is not an alias
+	if strings.Contains(host, ":") {
+		return hostAddr(host), cfg, nil
+	}
+	hc, ok := lookupSSHConfig(host)
+	if !ok {
+		return hostAddr(host), cfg, nil
+	}
+	addr := hostAddr(host)
+	if hc.HostName != "" {
+		addr = hostAddr(hc.HostName)
+	}
+	if hc.Port > 0 {
+		h, _, _ := net.SplitHostPort(addr)
+		addr = net.JoinHostPort(h, strconv.Itoa(hc.Port))
+	}
+	if hc.User == "" && len(hc.IdentityFiles) == 0 {
+		return addr, cfg, nil
+	}
+	hcfg := *cfg
+	if hc.User != "" {
+		hcfg.User = hc.User
+	}
+	if len(hc.IdentityFiles) > 0 || hcfg.Auth == nil {
+		a := C.Auth
+		if len(hc.IdentityFiles) > 0 {
+			a.PrivateKey = hc.IdentityFiles[0]
+			a.PrivateKeyPhrase = ""
+		}
+		auth, err := authMethods(&a)
+		if err != nil {
+			return "", nil, err
+		}
+		hcfg.Auth = auth
+	}
+	return addr, &hcfg, nil
+}
+
+// expandHome replace leading ~/ with home dir
+func expandHome(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		return homeDir() + p[1:]
+	}
+	return p
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RunSummary count of hosts by outcome of a run
+type RunSummary struct {
+	Hosts       int
+	OK          int
+	Failed      int // non-zero exit or other command error
+	TimedOut    int
+	Unreachable int // dial or auth failed
+	Canceled    int
+	Elapsed     time.Duration // wall clock time of run
+}
+
+// String like "42 hosts: 40 ok, 1 failed, 1 timed out in 3.2s", zero counts are left out
+func (s RunSummary) String() string {
+	parts := []string{fmt.Sprintf("%d ok", s.OK)}
+	for _, c := range []struct {
+		n    int
+		name string
+	}{{s.Failed, "failed"}, {s.TimedOut, "timed out"}, {s.Unreachable, "unreachable"}, {s.Canceled, "canceled"}} {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.name))
+		}
+	}
+	return fmt.Sprintf("%d hosts: %s in %s", s.Hosts, strings.Join(parts, ", "), s.Elapsed.Round(time.Millisecond))
+}
+
+// Summary count hosts of last run by outcome
+func (rc *RemoteCommand) Summary() RunSummary {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	s := RunSummary{Elapsed: rc.elapsed}
+	var dialErr *DialError
+	var authErr *AuthError
+	for _, h := range rc.hostList() {
+		s.Hosts++
+		err := rc.errs[h]
+		switch {
+		case err == nil && rc.ExitCode[h] == 0:
+			s.OK++
+		case errors.Is(err, errTimedOut):
+			s.TimedOut++
+		case errors.Is(err, errCanceled):
+			s.Canceled++
+		case errors.As(err, &dialErr), errors.As(err, &authErr):
+			s.Unreachable++
+		default:
+			s.Failed++
+		}
+	}
+	return s
+}
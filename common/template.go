@@ -0,0 +1,68 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// CommandVars data of command template rendered for a host
+type CommandVars struct {
+	Host  string
+	Index int               // position of host in host list, from 0
+	Vars  map[string]string // HostVars of host
+}
+
+// renderCommands render Cmd as text/template for every host if Template is set,
+// any parse or render error fails before connecting
+func (rc *RemoteCommand) renderCommands() error {
+	rc.cmds = nil
+	if !rc.Template {
+		return nil
+	}
+	cmds, err := rc.renderTemplate("command", rc.Cmd)
+	if err != nil {
+		return err
+	}
+	rc.cmds = cmds
+	return nil
+}
+
+// renderTemplate render text as text/template for every host, kind names it in errors
+func (rc *RemoteCommand) renderTemplate(kind, text string) (map[string]string, error) {
+	tpl, err := template.New(kind).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s template: %v", kind, err)
+	}
+	rendered := make(map[string]string)
+	for i, h := range rc.hostList() {
+		vars := rc.HostVars[h]
+		if vars == nil {
+			vars = map[string]string{}
+		}
+		var b bytes.Buffer
+		if err = tpl.Execute(&b, CommandVars{Host: h, Index: rc.indexOffset + i, Vars: vars}); err != nil {
+			return nil, fmt.Errorf("%s template of %s: %v", kind, h, err)
+		}
+		rendered[h] = b.String()
+	}
+	return rendered, nil
+}
+
+// command get command to run on host, in its work dir if any
+func (rc *RemoteCommand) command(host string) string {
+	cmd, ok := rc.cmds[host]
+	if !ok {
+		cmd = rc.Cmd
+	}
+	if c, ok := rc.Commands[host]; ok {
+		cmd = c
+	}
+	if dir := rc.workDir(host); dir != "" {
+		cmd = workDirCommand(dir, cmd)
+	}
+	if rc.Base64 {
+		cmd = base64Command(cmd)
+	}
+	return cmd
+}
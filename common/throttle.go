@@ -0,0 +1,123 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttle paces bytes to rate per second, shared by transfers it limits together
+type throttle struct {
+	rate int64
+	lock sync.Mutex
+	next time.Time // when bytes passed so far are due at rate
+}
+
+// newThrottle throttle of rate bytes per second, nil if rate<=0 which means no limit
+func newThrottle(rate int64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	return &throttle{rate: rate}
+}
+
+// wait block until n more bytes fit into rate, unused time is not saved up for bursts
+func (t *throttle) wait(ctx context.Context, n int) error {
+	t.lock.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.rate))
+	d := t.next.Sub(now)
+	t.lock.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk read size of limited transfer, about a tenth of a second at slowest rate
+func chunk(limits []*throttle) int {
+	size := 32 * 1024
+	for _, t := range limits {
+		if c := int(t.rate / 10); c < size {
+			size = c
+		}
+	}
+	if size < 512 {
+		size = 512
+	}
+	return size
+}
+
+// throttledReader reader paced by every non-nil throttle
+type throttledReader struct {
+	ctx    context.Context
+	r      io.Reader
+	limits []*throttle
+	size   int
+}
+
+// throttleReader limit r by throttles, r as is if all are nil
+func throttleReader(ctx context.Context, r io.Reader, limits ...*throttle) io.Reader {
+	var ls []*throttle
+	for _, t := range limits {
+		if t != nil {
+			ls = append(ls, t)
+		}
+	}
+	if len(ls) == 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limits: ls, size: chunk(ls)}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.size {
+		p = p[:t.size]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limits {
+		if werr := l.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ParseRate parse bytes per second like 500K, 10M or 1G, suffixes are powers of 1024
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	mul := int64(1)
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'K':
+			mul = 1 << 10
+		case 'M':
+			mul = 1 << 20
+		case 'G':
+			mul = 1 << 30
+		}
+		if mul > 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad rate %q, use bytes per second like 500K or 10M", s)
+	}
+	return int64(n * float64(mul)), nil
+}
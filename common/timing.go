@@ -0,0 +1,49 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// HostTiming time spent on each stage of a host
+type HostTiming struct {
+	Dial    time.Duration // tcp connect and ssh handshake, retries included
+	Session time.Duration // open session
+	Command time.Duration // run command until exit
+}
+
+// Total time spent on host
+func (t HostTiming) Total() time.Duration {
+	return t.Dial + t.Session + t.Command
+}
+
+// SlowestHosts hosts sorted by total time desc, n<=0 means all
+func (rc *RemoteCommand) SlowestHosts(n int) []string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	hosts := make([]string, 0, len(rc.Timing))
+	for _, h := range rc.hostList() {
+		if _, ok := rc.Timing[h]; ok {
+			hosts = append(hosts, h)
+		}
+	}
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return rc.Timing[hosts[i]].Total() > rc.Timing[hosts[j]].Total()
+	})
+	if n > 0 && n < len(hosts) {
+		hosts = hosts[:n]
+	}
+	return hosts
+}
+
+// PrintTiming print timing of n slowest hosts
+func (rc *RemoteCommand) PrintTiming(w io.Writer, n int) {
+	fmt.Fprintln(w, "================================= TIMING =================================")
+	fmt.Fprintf(w, "%21s %10s %10s %10s %10s\n", "host", "dial", "session", "command", "total")
+	for _, h := range rc.SlowestHosts(n) {
+		t := rc.Timing[h]
+		fmt.Fprintf(w, "%21s %10.3f %10.3f %10.3f %10.3f\n", h, t.Dial.Seconds(), t.Session.Seconds(), t.Command.Seconds(), t.Total().Seconds())
+	}
+}
@@ -1,12 +1,12 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +25,9 @@ const (
 )
 
 // Transfer transfer files via ssh
+//
+// Deprecated: use RemoteCommand.UploadContext and DownloadContext, which fail per host and
+// honor parallel, jump host, pool and retry settings
 type Transfer struct {
 	Inited         bool
 	Method         string // GET,PUT
@@ -212,27 +215,19 @@ func (t *Transfer) put(sc *sftp.Client, c *ssh.Client, localPath, remotePath str
 		return
 	}
 	defer srcFile.Close()
-	dstFile, err := sc.OpenFile(remotePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	fi, err := srcFile.Stat()
 	if err != nil {
 		return
 	}
-	defer dstFile.Close()
 	ft := FileTransfer{
 		Source: srcFile.Name(),
-		Target: dstFile.Name(),
+		Target: remotePath,
 	}
 	ts := time.Now()
-	var size int64
-	buf := make([]byte, 1024)
-	for {
-		n, _ := srcFile.Read(buf)
-		if n < 1 {
-			break
-		}
-		size = size + int64(n)
-		dstFile.Write(buf[0:n])
+	opts := putOptions{remotePath: remotePath, mode: fi.Mode().Perm()}
+	if ft.Size, err = putFile(c, sc, srcFile, opts); err != nil {
+		return
 	}
-	ft.Size = size
 	ft.Elapse = time.Now().Sub(ts)
 	addr := c.Conn.RemoteAddr().String()
 	t.Lock.Lock()
@@ -246,17 +241,35 @@ func (t *Transfer) initClient() error {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	hostKeyCallback, err := HostKeyCallback()
+	if err != nil {
+		return err
+	}
+	timeout := 30 * time.Second
+	if C.Server.ConnectTimeout > 0 {
+		timeout = C.Server.ConnectTimeout
+	}
+	algorithms, err := sshConfig()
+	if err != nil {
+		return err
+	}
 	clientConfig := &ssh.ClientConfig{
+		Config:          algorithms,
 		User:            C.Auth.User,
 		Auth:            auth,
-		Timeout:         30 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+		HostKeyCallback: hostKeyCallback,
 	}
 	for _, h := range t.Hosts {
-		if strings.Index(h, ":") < 0 {
-			h = h + ":" + strconv.Itoa(C.Server.DefaultPort)
+		user, host := splitUser(h)
+		cfg := clientConfig
+		if user != "" {
+			ucfg := *clientConfig
+			ucfg.User = user
+			cfg = &ucfg
 		}
-		client, err := ssh.Dial("tcp", h, clientConfig)
+		h = hostAddr(host)
+		client, err := dial(context.Background(), nil, h, cfg)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Upload copy local file to remotePath of every host by sftp, see UploadContext
+func (rc *RemoteCommand) Upload(localPath, remotePath string) error {
+	return rc.UploadContext(context.Background(), localPath, remotePath)
+}
+
+// UploadContext copy local file to remotePath of every host in parallel, with same dial
+// and concurrency settings as commands. remotePath ending with / is a dir, missing dirs
+// are created. The file is written to a temp file next to remotePath and renamed over it
+// once complete, so readers never see a partial file. Replaced files keep their mode and
+// owner, new ones get the local mode. Per-host failures are recorded in Error
+func (rc *RemoteCommand) UploadContext(ctx context.Context, localPath, remotePath string) error {
+	return rc.upload(ctx, localPath, remotePath, nil)
+}
+
+// UploadTemplate render local file per host and upload results, see UploadTemplateContext
+func (rc *RemoteCommand) UploadTemplate(localPath, remotePath string) error {
+	return rc.UploadTemplateContext(context.Background(), localPath, remotePath)
+}
+
+// UploadTemplateContext render local file as text/template for every host with the
+// CommandVars of command templates, and upload each host its own result like UploadContext.
+// Any parse or render error fails before connecting
+func (rc *RemoteCommand) UploadTemplateContext(ctx context.Context, localPath, remotePath string) error {
+	text, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	rendered, err := rc.renderTemplate("file", string(text))
+	if err != nil {
+		return err
+	}
+	return rc.upload(ctx, localPath, remotePath, rendered)
+}
+
+// upload copy local file, or content of host rendered from it if not nil, to remotePath of every host
+func (rc *RemoteCommand) upload(ctx context.Context, localPath, remotePath string, rendered map[string]string) error {
+	if rc.PipeMode {
+		return errors.New("upload is not supported in pipe mode")
+	}
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return errors.New("Local is dir,recursive transfer not supported now")
+	}
+	if strings.HasSuffix(remotePath, "/") {
+		remotePath = path.Join(remotePath, path.Base(localPath))
+	}
+	opts := putOptions{remotePath: remotePath, mode: fi.Mode().Perm(), backup: rc.BackupUpload, noReplace: rc.NoReplace}
+	if rc.VerifyChecksum && rendered == nil {
+		if opts.sum, err = fileSHA256(localPath); err != nil {
+			return err
+		}
+	}
+	total := newThrottle(rc.BandwidthLimit)
+	return rc.run(ctx, func(ctx context.Context, ohost string, client *ssh.Client, timing *HostTiming, ready func()) {
+		ts := time.Now()
+		var src io.Reader
+		hopts := opts
+		if rendered != nil {
+			src = strings.NewReader(rendered[ohost])
+			if rc.VerifyChecksum {
+				sum := sha256.Sum256([]byte(rendered[ohost]))
+				hopts.sum = hex.EncodeToString(sum[:])
+			}
+		} else {
+			f, err := os.Open(localPath)
+			if err != nil {
+				rc.setError(ohost, err)
+				return
+			}
+			defer f.Close()
+			src = f
+		}
+		src = throttleReader(ctx, src, total, newThrottle(rc.HostBandwidthLimit))
+		size, err := uploadFile(client, src, hopts)
+		timing.Command = time.Since(ts)
+		if err != nil {
+			rc.setError(ohost, err)
+			return
+		}
+		rc.lock.Lock()
+		rc.Output[ohost] = fmt.Sprintf("%s => %s %dByte %.2f seconds", localPath, remotePath, size, timing.Command.Seconds())
+		if hopts.sum != "" {
+			rc.Output[ohost] += " sha256 ok"
+		}
+		rc.lock.Unlock()
+	})
+}
+
+// putOptions how putFile writes remotePath
+type putOptions struct {
+	remotePath string
+	mode       os.FileMode // mode of new file, replaced file keeps its mode
+	sum        string      // sha256 the written file must have, not checked if empty
+	backup     bool        // keep replaced file as remotePath.bak
+	noReplace  bool        // fail if remotePath exists
+}
+
+// uploadFile copy src to remote by sftp, see putFile
+func uploadFile(client *ssh.Client, src io.Reader, opts putOptions) (int64, error) {
+	sc, err := sftp.NewClient(client, sftp.MaxPacket(33788))
+	if err != nil {
+		return 0, err
+	}
+	defer sc.Close()
+	return putFile(client, sc, src, opts)
+}
+
+// putFile write src to a temp file in dir of remotePath, creating dir if not exists, and
+// rename it over remotePath once written and verified. The temp file is removed on failure
+func putFile(client *ssh.Client, sc *sftp.Client, src io.Reader, opts putOptions) (size int64, err error) {
+	dir := path.Dir(opts.remotePath)
+	if err = sc.MkdirAll(dir); err != nil {
+		return 0, fmt.Errorf("mkdir %s: %v", dir, err)
+	}
+	old, err := sc.Stat(opts.remotePath)
+	if err == nil && old.IsDir() {
+		return 0, fmt.Errorf("%s is a dir", opts.remotePath)
+	}
+	if old != nil && opts.noReplace {
+		return 0, fmt.Errorf("remote file %s exists", opts.remotePath)
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	tmp := path.Join(dir, fmt.Sprintf(".%s.%x.tmp", path.Base(opts.remotePath), suffix))
+	dst, err := sc.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL)
+	if err != nil {
+		return 0, fmt.Errorf("open %s: %v", tmp, err)
+	}
+	defer func() {
+		if err != nil {
+			sc.Remove(tmp)
+		}
+	}()
+	size, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return size, err
+	}
+	if opts.sum != "" {
+		if err = verifyChecksum(client, tmp, opts.sum); err != nil {
+			return size, fmt.Errorf("%s: %v", opts.remotePath, err)
+		}
+	}
+	mode := opts.mode
+	if old != nil {
+		mode = old.Mode().Perm()
+	}
+	if err = sc.Chmod(tmp, mode); err != nil {
+		return size, fmt.Errorf("chmod %s: %v", tmp, err)
+	}
+	if err = keepOwner(sc, tmp, old); err != nil {
+		return size, err
+	}
+	if old != nil && opts.backup {
+		bak := opts.remotePath + ".bak"
+		sc.Remove(bak)
+		if err = sc.Link(opts.remotePath, bak); err != nil {
+			return size, fmt.Errorf("backup %s: %v", bak, err)
+		}
+	}
+	if err = sc.PosixRename(tmp, opts.remotePath); err != nil {
+		return size, fmt.Errorf("rename %s to %s: %v", tmp, opts.remotePath, err)
+	}
+	return size, nil
+}
+
+// keepOwner give file owner and group of replaced file old, nothing to do for new files
+func keepOwner(sc *sftp.Client, file string, old os.FileInfo) error {
+	if old == nil {
+		return nil
+	}
+	want, ok := old.Sys().(*sftp.FileStat)
+	if !ok {
+		return nil
+	}
+	fi, err := sc.Stat(file)
+	if err != nil {
+		return err
+	}
+	if got, ok := fi.Sys().(*sftp.FileStat); ok && got.UID == want.UID && got.GID == want.GID {
+		return nil
+	}
+	if err = sc.Chown(file, int(want.UID), int(want.GID)); err != nil {
+		return fmt.Errorf("chown %s to %d:%d of replaced file: %v", file, want.UID, want.GID, err)
+	}
+	return nil
+}
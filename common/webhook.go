@@ -0,0 +1,123 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// defaults of webhook notification
+const (
+	DefaultWebhookTimeout = 10 * time.Second
+	DefaultWebhookRetries = 3
+)
+
+// Webhook endpoint notified with a json summary when a run finishes
+type Webhook struct {
+	URL     string            `yaml:"url"`
+	Timeout time.Duration     `yaml:"timeout"` // per attempt, default 10s
+	Retries int               `yaml:"retries"` // attempts after the first one, default 3, -1 disables
+	Headers map[string]string `yaml:"headers"` // like Authorization
+}
+
+// WebhookPayload json body posted to webhook
+type WebhookPayload struct {
+	Command     string    `json:"command"`
+	Started     time.Time `json:"started"`
+	DurationMs  int64     `json:"duration_ms"`
+	Hosts       int       `json:"hosts"`
+	OK          int       `json:"ok"`
+	Failed      int       `json:"failed"` // hosts failed for any reason, same as len(failed_hosts)
+	TimedOut    int       `json:"timed_out"`
+	Unreachable int       `json:"unreachable"`
+	Canceled    int       `json:"canceled"`
+	FailedHosts []string  `json:"failed_hosts"`
+}
+
+// WebhookPayload summary of last run, command is redacted
+func (rc *RemoteCommand) WebhookPayload() WebhookPayload {
+	s := rc.Summary()
+	failed := rc.ErrorHosts()
+	if failed == nil {
+		failed = []string{}
+	}
+	rc.lock.Lock()
+	started := rc.started
+	rc.lock.Unlock()
+	return WebhookPayload{
+		Command:     rc.redact(rc.Cmd),
+		Started:     started,
+		DurationMs:  s.Elapsed.Milliseconds(),
+		Hosts:       s.Hosts,
+		OK:          s.OK,
+		Failed:      len(failed),
+		TimedOut:    s.TimedOut,
+		Unreachable: s.Unreachable,
+		Canceled:    s.Canceled,
+		FailedHosts: failed,
+	}
+}
+
+// Notify post WebhookPayload of last run to w.URL. Network errors, 429 and 5xx replies are retried
+// with backoff starting at 1s, every attempt is bounded by w.Timeout
+func (rc *RemoteCommand) Notify(ctx context.Context, w Webhook) error {
+	body, err := json.Marshal(rc.WebhookPayload())
+	if err != nil {
+		return err
+	}
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	retries := w.Retries
+	if retries == 0 {
+		retries = DefaultWebhookRetries
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(ctx, w, body, timeout)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= retries {
+			return fmt.Errorf("webhook %s: %v", w.URL, err)
+		}
+		L.Debugf("webhook: attempt %d failed, retry in %s: %v", attempt+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook %s: %v", w.URL, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postWebhook post body once, retry tells whether the failure is worth another attempt
+func postWebhook(ctx context.Context, w Webhook, body []byte, timeout time.Duration) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
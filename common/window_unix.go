@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package common
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// watchWindowSize forward local terminal resizes to sess until stop is called
+func watchWindowSize(fd int, sess *ssh.Session) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				if cols, rows, err := terminal.GetSize(fd); err == nil {
+					sess.WindowChange(rows, cols)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
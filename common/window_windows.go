@@ -0,0 +1,8 @@
+package common
+
+import "golang.org/x/crypto/ssh"
+
+// watchWindowSize no resize signal on windows
+func watchWindowSize(fd int, sess *ssh.Session) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// workDirMissing stderr line and exit status of command when work dir can not be entered
+const (
+	workDirMissing     = "optool: can not cd to work dir"
+	workDirMissingCode = 125
+)
+
+// workDir get work dir of host, WorkDirs overrides WorkDir
+func (rc *RemoteCommand) workDir(host string) string {
+	if dir, ok := rc.WorkDirs[host]; ok {
+		return dir
+	}
+	return rc.WorkDir
+}
+
+// workDirCommand wrap cmd to run in dir, failing instead of running in home dir if dir is missing.
+// leading ~/ is expanded to remote HOME
+func workDirCommand(dir, cmd string) string {
+	qdir := shellQuote(dir)
+	if dir == "~" {
+		qdir = `"$HOME"`
+	} else if strings.HasPrefix(dir, "~/") {
+		qdir = `"$HOME"/` + shellQuote(dir[2:])
+	}
+	return fmt.Sprintf("cd -- %s 2>/dev/null || { echo %s >&2; exit %d; }; %s",
+		qdir, shellQuote(workDirMissing+": "+dir), workDirMissingCode, cmd)
+}
@@ -0,0 +1,219 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// x11Display local X server parsed from DISPLAY
+type x11Display struct {
+	network string
+	addr    string
+	screen  uint32
+	name    string // DISPLAY as is, for xauth
+}
+
+// parseDisplay parse DISPLAY like :0, :0.1, unix:0, host:10.0 or a socket path as set by
+// XQuartz, like /private/tmp/com.apple.launchd.XXX/org.xquartz:0
+func parseDisplay(display string) (*x11Display, error) {
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("bad DISPLAY %q", display)
+	}
+	host, num := display[:i], display[i+1:]
+	screen := "0"
+	if j := strings.Index(num, "."); j >= 0 {
+		num, screen = num[:j], num[j+1:]
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return nil, fmt.Errorf("bad DISPLAY %q", display)
+	}
+	s, err := strconv.ParseUint(screen, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad DISPLAY %q", display)
+	}
+	d := &x11Display{screen: uint32(s), name: display}
+	if host == "" || host == "unix" {
+		d.network, d.addr = "unix", "/tmp/.X11-unix/X"+num
+	} else if strings.HasPrefix(host, "/") {
+		// socket file of XQuartz, its name ends with :<display>
+		d.network, d.addr = "unix", host+":"+num
+	} else {
+		d.network, d.addr = "tcp", net.JoinHostPort(host, strconv.Itoa(6000+n))
+	}
+	return d, nil
+}
+
+// x11Cookie MIT-MAGIC-COOKIE-1 of display from xauth, nil if xauth has none
+func x11Cookie(display string) []byte {
+	out, err := exec.Command("xauth", "list", display).Output()
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[1] == "MIT-MAGIC-COOKIE-1" {
+			if cookie, err := hex.DecodeString(f[2]); err == nil {
+				return cookie
+			}
+		}
+	}
+	return nil
+}
+
+// x11Proxy proxy of x11 channels of a connection to local display. Like ssh -X the remote
+// host only gets a random fake cookie, which is swapped for the real one in the setup of
+// every channel, so the real cookie never leaves this host
+type x11Proxy struct {
+	display *x11Display
+	fake    []byte
+	real    []byte // nil if xauth has none, setup is passed on with the fake cookie
+}
+
+// newX11Proxy proxy to display d with a fake cookie as long as its real one
+func newX11Proxy(d *x11Display) (*x11Proxy, error) {
+	p := &x11Proxy{display: d, real: x11Cookie(d.name)}
+	n := len(p.real)
+	if n == 0 {
+		L.Debugf("x11: no xauth cookie of %s, using fake one", d.name)
+		n = 16
+	}
+	p.fake = make([]byte, n)
+	if _, err := rand.Read(p.fake); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// forwardX11 request X11 forwarding on sess and proxy X11 channels of client to local DISPLAY.
+// no-op with a warning if there is no usable local X server
+func forwardX11(client *ssh.Client, sess *ssh.Session) error {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		L.Warnf("x11 forwarding disabled: DISPLAY is not set")
+		return nil
+	}
+	d, err := parseDisplay(display)
+	if err != nil {
+		L.Warnf("x11 forwarding disabled: %v", err)
+		return nil
+	}
+	conn, err := net.Dial(d.network, d.addr)
+	if err != nil {
+		L.Warnf("x11 forwarding disabled: no X server at %s: %v", display, err)
+		return nil
+	}
+	conn.Close()
+	// later sessions of a pooled connection reuse the proxy of the first one
+	state, err := channelHandler(client, "x11", func() (interface{}, error) {
+		p, err := newX11Proxy(d)
+		if err != nil {
+			return nil, err
+		}
+		chans := client.HandleChannelOpen("x11")
+		if chans == nil {
+			return nil, errors.New("x11 channels already handled on connection")
+		}
+		go func() {
+			for nc := range chans {
+				go p.serve(nc)
+			}
+		}()
+		return p, nil
+	})
+	if err != nil {
+		return err
+	}
+	p := state.(*x11Proxy)
+	req := struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}{false, "MIT-MAGIC-COOKIE-1", hex.EncodeToString(p.fake), p.display.screen}
+	ok, err := sess.SendRequest("x11-req", true, ssh.Marshal(&req))
+	if err == nil && !ok {
+		err = errors.New("x11-req rejected, check X11Forwarding of sshd")
+	}
+	return err
+}
+
+// serve copy data between x11 channel and local X server until either side closes,
+// once the fake cookie in connection setup is checked and replaced
+func (p *x11Proxy) serve(nc ssh.NewChannel) {
+	conn, err := net.Dial(p.display.network, p.display.addr)
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+	setup, err := p.setup(ch)
+	if err != nil {
+		L.Warnf("x11 connection rejected: %v", err)
+		return
+	}
+	if _, err = conn.Write(setup); err != nil {
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, ch)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(ch, conn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// setup read connection setup of x11 client from r, get it with the fake cookie checked and
+// replaced by the real one
+func (p *x11Proxy) setup(r io.Reader) ([]byte, error) {
+	// byte order, unused, protocol major and minor, auth name and data lengths, unused
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch head[0] {
+	case 'B':
+		order = binary.BigEndian
+	case 'l':
+		order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("bad byte order %#x in setup", head[0])
+	}
+	pad := func(n int) int { return (n + 3) &^ 3 }
+	nameLen, dataLen := int(order.Uint16(head[6:])), int(order.Uint16(head[8:]))
+	body := make([]byte, pad(nameLen)+pad(dataLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	name, data := body[:nameLen], body[pad(nameLen):pad(nameLen)+dataLen]
+	if string(name) != "MIT-MAGIC-COOKIE-1" || subtle.ConstantTimeCompare(data, p.fake) != 1 {
+		return nil, errors.New("wrong auth cookie")
+	}
+	if p.real != nil {
+		copy(data, p.real)
+	}
+	return append(head, body...), nil
+}
@@ -0,0 +1,18 @@
+package common
+
+import (
+	"io"
+
+	"github.com/go-yaml/yaml"
+)
+
+// YAMLPrint print results as yaml sequence with the json field names,
+// multiline outputs are written as literal block scalars
+func (rc *RemoteCommand) YAMLPrint(w io.Writer) error {
+	data, err := yaml.Marshal(rc.Result())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -33,30 +39,123 @@ var (
 	pTagArgs      = flag.String("ta", "", "append tagged command parameters, overflow params will be dropped, separated by comma(,).\n\t to replace in tags use string: _REPLACE_")
 	pTagPrint     = flag.Bool("tp", false, "print tag line")
 	pTagList      = flag.Bool("tl", false, "list all tags")
-	pGzip         = flag.Bool("gz", false, "enable gzip for transfer./usr/bin/gzip must be executable at remote host")
-	pGroup        = flag.String("g", "", "set default group name for hosts")
+	pGzip         = flag.Bool("gz", false, "enable gzip for transfer.gzip must be in PATH of remote host or set by gzip_path")
+	pGzipLevel    = flag.Int("gzlevel", 0, "set gzip compression level 1-9, 1=fastest 9=smallest")
+	pBase64       = flag.Bool("b64", false, "pass output through base64 at remote host and decode it locally, keeps binary output intact for -odir. base64 must be in PATH of remote host")
+	pGroup        = flag.String("g", "", "set default group name for hosts, groups in inventory can be joined by colon(web:db)")
+	pMatch        = flag.String("match", "", "run on inventory or configured hosts matching glob patterns separated by comma(,), like 'web-*.us-east'")
+	pInventory    = flag.String("inventory", "", "set inventory file of host groups")
 	pUser         = flag.String("u", "", "set ssh auth user")
 	pOutput       = flag.String("o", "-", "set output file")
+	pOutputDir    = flag.String("odir", "", "write output of each host to <odir>/<host>.out and <host>.err")
 	pCommand      = flag.String("x", "", "execute command directly")
 	pScript       = flag.String("s", "", "read commands from script")
+	pRunScript    = flag.String("script", "", "run local script file on hosts through stdin of remote shell, arguments after flags are passed to script")
+	pTemplate     = flag.Bool("template", false, "render command, or file of -put, as go text/template per host, like app@{{.Index}} or {{.Host}}")
+	pWorkDir      = flag.String("workdir", "", "run command in directory at remote host, hosts without it fail")
+	pDryRun       = flag.Bool("dryrun", false, "print command that would run on each host without connecting")
+	pConfirm      = flag.Bool("confirm", false, "ask before running, also done if hosts exceed confirm_hosts of config")
+	pYes          = flag.Bool("yes", false, "skip confirmation of -confirm and confirm_hosts")
+	pStripANSI    = flag.Bool("strip-ansi", false, "strip ansi escape sequences like colors from output and stderr")
+	pSummary      = flag.Bool("summary", false, "print count of hosts by outcome and run time to stderr")
+	pWebhook      = flag.String("webhook", "", "post json summary of run to url when it finishes, overrides url of webhook config")
+	pProgress     = flag.Bool("progress", false, "show count of finished hosts on stderr while running, if it is a terminal")
+	pLive         = flag.Bool("live", false, "print output lines as they arrive, prefixed by host")
+	pFailOn       = flag.String("failon", "any", "exit 1 if hosts failed: any=errors or non-zero remote exit, errors=connection and exec errors only, none=always 0")
+	pMerge        = flag.Bool("merge", false, "merge stderr into output in arrival order, not supported with -gz")
+	pShell        = flag.Bool("shell", false, "open interactive shell on the only host given")
+	pSteps        = flag.String("steps", "", "run commands of file line by line in one shell per host, stop host at first failure")
+	pContinue     = flag.Bool("continue", false, "with -steps keep running commands after one failed")
+	pMaxOutput    = flag.Int64("maxout", 0, "kill command of a host once its output exceeds N bytes")
+	pForwardAgent = flag.Bool("A", false, "forward local ssh-agent to remote commands")
+	pForwardX11   = flag.Bool("X", false, "forward X11 of remote commands to local DISPLAY")
+	pForward      = flag.String("L", "", "forward local ports through the only host given, [bind:]port:host:hostport separated by comma(,)")
+	pBanner       = flag.Bool("banner", false, "print login banners of hosts")
 	pNoHeader     = flag.Int("nh", 0, "(1)1<<0=no header,(2)1<<1=no server ip,3=none")
-	pHost         = flag.String("host", "", "set run host")
+	pJSON         = flag.Bool("json", false, "print results as json")
+	pYAML         = flag.Bool("yaml", false, "print results as yaml")
+	pCSV          = flag.Bool("csv", false, "print results as csv: host,exit_code,output,error")
+	pTSV          = flag.Bool("tsv", false, "print results as tab separated values, like -csv")
+	pValueOnly    = flag.Bool("valueonly", false, "print only host,output columns with -csv or -tsv")
+	pColor        = flag.String("color", "auto", "colorize output: auto,always,never. auto is disabled if not a terminal or NO_COLOR is set")
+	pGrouped      = flag.Bool("grouped", false, "print identical outputs once with hosts producing them")
+	pAggregate    = flag.Bool("agg", false, "print sum, mean, min and max of hosts outputting a single number")
+	pHistogram    = flag.Bool("hist", false, "print count of hosts per distinct trimmed output, most common first")
+	pHistHosts    = flag.Bool("histhosts", false, "with -hist also list hosts of every output")
+	pErrorsOnly   = flag.Bool("errors", false, "print errors only, skip output section")
+	pFailedOnly   = flag.Bool("failed", false, "print output of hosts with non-zero exit code only")
+	pMarkEmpty    = flag.Bool("empty", false, "mark hosts succeeded without output as (empty)")
+	pSort         = flag.String("sort", "", "print hosts sorted: lexical, or natural where web2 comes before web10. default input order")
+	pGrep         = flag.String("grep", "", "keep only output lines matching regexp")
+	pGrepInvert   = flag.Bool("invert", false, "with -grep keep lines not matching")
+	pGrepCount    = flag.Bool("count", false, "with -grep print number of kept lines instead")
+	pRedact       = flag.String("redact", "", "mask output matching regexp as ***, in addition to redact of config")
+	pHead         = flag.Int("head", 0, "print only first N lines of output per host")
+	pTail         = flag.Int("tail", 0, "print only last N lines of output per host")
+	pDiffersOnly  = flag.Bool("differs", false, "with -grouped skip hosts producing the most common output")
+	pDiff         = flag.String("diff", "", "print output diff of hosts against baseline host, auto=most common output")
+	pTTY          = flag.Bool("tty", false, "allocate a pty for commands need a terminal, stderr is merged into output")
+	pSudo         = flag.Bool("sudo", false, "run command by sudo, auth password is used as sudo password")
+	pHost         = flag.String("host", "", "set run host, user@host:port overrides user and port. ranges can be used like app[01-10,15]. - reads hosts from stdin")
+	pEC2          = flag.String("ec2", "", "run on running ec2 instances having all tags, like Role=web,Env=prod")
+	pPlan         = flag.String("plan", "", "run own command of each host from json file, - reads stdin: [{\"host\":\"web1\",\"cmd\":\"uptime\",\"env\":{},\"workdir\":\"/srv\"}]")
+	pConsul       = flag.String("consul", "", "run on instances of consul service passing health checks, like web or web:tag")
+	pPods         = flag.String("pods", "", "run in kubernetes pods by kubectl exec: label selector like app=web, or pods like ns/pod1,ns/pod2")
+	pNamespace    = flag.String("namespace", "", "namespace of -pods, default current namespace of kubectl")
+	pContainer    = flag.String("container", "", "container of -pods, default container of pod")
+	pExclude      = flag.String("exclude", "", "skip hosts by names or glob patterns separated by comma(,), like web3,db-*")
+	pExcludeFile  = flag.String("excludefile", "", "skip hosts or glob patterns listed in file, one per line")
 	pPort         = flag.Int("port", 0, "set default ssh port")
+	pTimeout      = flag.Duration("timeout", 0, "kill command running longer than timeout on a host, like 10m")
+	pRunTimeout   = flag.Duration("runtimeout", 0, "abort whole run after duration, hosts not done fail as timed out, like 5m")
+	pConnTimeout  = flag.Duration("ctimeout", 0, "set tcp connect and ssh handshake timeout, like 5s (default 10s)")
+	pParallel     = flag.Int("parallel", 0, "set max hosts executing at once")
+	pDialRate     = flag.Float64("rate", 0, "set max new connections per second")
+	pRetry        = flag.Int("retry", 0, "set max connect attempts per host on connection errors")
+	pMaxFail      = flag.Int("maxfail", 0, "abort remaining hosts once N hosts failed")
+	pCanary       = flag.String("canary", "", "run first N or N% of hosts first, remaining hosts only if none of them failed")
+	pFailFast     = flag.Bool("failfast", false, "abort remaining hosts as soon as one host failed")
+	pSerial       = flag.Bool("serial", false, "run hosts one at a time in input order, with -failfast a failure stops the rollout")
+	pSerialDelay  = flag.Duration("serialdelay", 0, "with -serial wait between hosts, like 30s")
+	pBatch        = flag.Int("batch", 0, "run hosts in batches of N, each batch finished before the next starts")
+	pBatchDelay   = flag.Duration("batchdelay", 0, "with -batch wait between batches, like 1m")
+	pPause        = flag.Bool("pause", false, "with -serial or -batch ask before each next host or batch")
+	pTiming       = flag.Int("timing", 0, "print dial/session/command seconds of N slowest hosts to stderr")
 	pPrivateKey   = flag.String("key", "", "set private key")
+	pDebug        = flag.Bool("debug", false, "log debug diagnostics to stderr")
 	pVerbose      = flag.Bool("v", false, "verbose all configs")
 	pSampleConfig = flag.Bool("V", false, "print sample configure")
 	pVersion      = flag.Bool("version", false, "print version and exit")
 	pEncrypt      = flag.Bool("encrypt", false, "encrypt a password/phrase")
 	//@todo
-	pGet      = flag.String("get", "", "get a file from remote host")
-	pPut      = flag.String("put", "", "put a file to remote host")
-	pPath     = flag.String("path", "", "set path.if get is set this is local path,if put is set this is remote path")
-	pOverride = flag.Bool("override", false, "Override remote file if exists")
+	pGet         = flag.String("get", "", "get remote files matching a path or glob like /var/log/*.log into <path>/<host>/")
+	pPut         = flag.String("put", "", "put a file to remote host")
+	pPath        = flag.String("path", "", "set path.if get is set this is local path,if put is set this is remote path")
+	pOverride    = flag.Bool("override", false, "Override remote file if exists")
+	pVerify      = flag.Bool("verify", false, "with -put compare sha256 of remote file with local file")
+	pBackup      = flag.Bool("backup", false, "with -put -override keep replaced remote file as <path>.bak")
+	pBWLimit     = flag.String("bwlimit", "", "limit get/put bandwidth summed over all hosts, bytes per second like 500K or 10M")
+	pHostBWLimit = flag.String("hostbwlimit", "", "limit get/put bandwidth of each host, bytes per second like 500K or 10M")
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Llongfile)
 	flag.Parse()
+	level := common.LevelWarn
+	if *pDebug {
+		level = common.LevelDebug
+	}
+	common.SetLogger(common.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile), level))
+	switch *pFailOn {
+	case "any", "errors", "none":
+	default:
+		log.Fatalln("Invalid failon:", *pFailOn)
+	}
+	switch *pSort {
+	case common.SortInput, common.SortLexical, common.SortNatural:
+	default:
+		log.Fatalln("Invalid sort:", *pSort)
+	}
 	if *pVersion {
 		fmt.Println("Opstool", OptoolVersion)
 		os.Exit(0)
@@ -109,55 +208,217 @@ func main() {
 	}
 	// gzip or not
 	common.C.Gzip = *pGzip
+	if *pGzipLevel > 0 {
+		common.C.GzipLevel = *pGzipLevel
+	}
 	// user
 	if *pUser != "" {
 		common.C.Auth.User = *pUser
 	}
 	// hosts
 	var hosts []string
-	if *pHost != "" {
+	var plan []common.PlanEntry
+	if *pPlan != "" {
+		in := os.Stdin
+		if *pPlan != "-" {
+			if in, err = os.Open(*pPlan); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		if plan, err = common.ReadPlan(in); err != nil {
+			log.Fatalln(err)
+		}
+		in.Close()
+		hosts = common.PlanHosts(plan)
+	} else if *pConsul != "" {
+		if hosts, err = common.DiscoverConsul(context.Background(), *pConsul); err != nil {
+			log.Fatalln(err)
+		}
+	} else if *pEC2 != "" {
+		if hosts, err = common.DiscoverEC2(context.Background(), *pEC2); err != nil {
+			log.Fatalln(err)
+		}
+	} else if *pPods != "" {
+		if hosts, err = common.ResolvePods(context.Background(), *pPods, *pNamespace); err != nil {
+			log.Fatalln(err)
+		}
+	} else if *pHost == "-" {
+		if hosts, err = common.ReadHosts(os.Stdin); err != nil {
+			log.Fatalln("Read hosts: ", err)
+		}
+	} else if *pHost != "" {
 		hosts = []string{*pHost}
 	} else {
 		var ok bool
 		if *pGroup != "" {
 			common.C.Server.DefaultGroup = *pGroup
 		}
-		if hosts, ok = common.C.Server.Hosts[common.C.Server.DefaultGroup]; !ok {
+		if *pInventory != "" {
+			common.C.Server.Inventory = *pInventory
+		}
+		if common.C.Server.Inventory != "" {
+			inv, err := common.LoadInventory(common.C.Server.Inventory)
+			if err != nil {
+				log.Fatalln("Inventory: ", err)
+			}
+			group := common.C.Server.DefaultGroup
+			if *pMatch != "" {
+				group = "all"
+			}
+			if hosts, err = inv.Expand(group); err != nil {
+				log.Fatalln(err)
+			}
+		} else if *pMatch != "" {
+			hosts = configuredHosts()
+		} else if hosts, ok = common.C.Server.Hosts[common.C.Server.DefaultGroup]; !ok {
 			log.Fatalln("Host group not found. Group: ", common.C.Server.DefaultGroup)
 		}
+		if *pMatch != "" {
+			if hosts, err = common.ExpandHosts(hosts); err != nil {
+				log.Fatalln(err)
+			}
+			if hosts, err = common.MatchHosts(hosts, strings.Split(*pMatch, ",")); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+	// app[01-10] like patterns
+	if hosts, err = common.ExpandHosts(hosts); err != nil {
+		log.Fatalln(err)
+	}
+	// excluded hosts
+	var excludes []string
+	if *pExclude != "" {
+		excludes = strings.Split(*pExclude, ",")
+	}
+	if *pExcludeFile != "" {
+		f, err := os.Open(*pExcludeFile)
+		if err != nil {
+			log.Fatalln("Exclude file: ", err)
+		}
+		patterns, err := common.ReadHosts(f)
+		f.Close()
+		if err != nil {
+			log.Fatalln("Exclude file: ", err)
+		}
+		excludes = append(excludes, patterns...)
+	}
+	if hosts, err = common.ExcludeHosts(hosts, excludes); err != nil {
+		log.Fatalln(err)
 	}
 	// port
 	if *pPort > 0 && *pPort < 65536 {
 		common.C.Server.DefaultPort = *pPort
 	}
+	// connect timeout
+	if *pConnTimeout > 0 {
+		common.C.Server.ConnectTimeout = *pConnTimeout
+	}
+	// parallel
+	if *pParallel > 0 {
+		common.C.Server.MaxParallel = *pParallel
+	}
+	// connection rate
+	if *pDialRate > 0 {
+		common.C.Server.DialRate = *pDialRate
+	}
 	// private key
 	if *pPrivateKey != "" {
 		common.C.Auth.PrivateKey = *pPrivateKey
 		common.C.Auth.PrivateKeyPhrase = ""
 	}
+	// interactive shell
+	if *pShell {
+		sh := common.NewRemoteCommand(hosts, "")
+		sh.ForwardX11 = *pForwardX11
+		if err = sh.Shell(); err != nil {
+			var ee *common.ExecError
+			if errors.As(err, &ee) && ee.ExitCode > 0 {
+				os.Exit(ee.ExitCode)
+			}
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+	// port forwarding
+	if *pForward != "" {
+		var forwards []common.PortForward
+		for _, spec := range strings.Split(*pForward, ",") {
+			f, err := common.ParsePortForward(spec)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			forwards = append(forwards, f)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			cancel()
+		}()
+		if err = common.NewRemoteCommand(hosts, "").ForwardContext(ctx, forwards...); err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
 	// Get/Put files
 	if *pGet != "" && *pPut != "" {
 		log.Fatalln("Get or put cannot be set at once")
 	}
-	transfer := &common.Transfer{
-		Inited: false,
+	var bwLimit, hostBWLimit int64
+	if *pBWLimit != "" {
+		if bwLimit, err = common.ParseRate(*pBWLimit); err != nil {
+			log.Fatalln(err)
+		}
 	}
-	if *pGet != "" {
-		transfer = common.NewTransfer(common.TransferGet, *pPath, *pGet, hosts)
-	} else if *pPut != "" {
-		transfer = common.NewTransfer(common.TransferPut, *pPut, *pPath, hosts)
+	if *pHostBWLimit != "" {
+		if hostBWLimit, err = common.ParseRate(*pHostBWLimit); err != nil {
+			log.Fatalln(err)
+		}
 	}
-	if transfer.Inited {
+	if *pGet != "" || *pPut != "" {
 		if common.C.TransferMaxSize < 1 {
 			common.C.TransferMaxSize = common.TransferDefaultMaxSize
 		}
-		if *pOverride {
-			transfer.Override = true
+		rc := common.NewRemoteCommand(hosts, "")
+		rc.RetryAttempts = *pRetry
+		rc.RunTimeout = *pRunTimeout
+		rc.SortHosts = *pSort
+		rc.VerifyChecksum = *pVerify
+		rc.BackupUpload = *pBackup
+		rc.BandwidthLimit, rc.HostBandwidthLimit = bwLimit, hostBWLimit
+		// Ctrl-C stops transfers
+		ctx, cancel := context.WithCancel(context.Background())
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			cancel()
+		}()
+		if *pGet != "" {
+			// files of each host go to <path>/<host>/
+			dir := *pPath
+			if dir == "" {
+				dir = "."
+			}
+			err = rc.DownloadContext(ctx, *pGet, dir)
+		} else if *pTemplate {
+			// rendered per host, always replaces remote file
+			err = rc.UploadTemplateContext(ctx, *pPut, *pPath)
+		} else {
+			rc.NoReplace = !*pOverride
+			err = rc.UploadContext(ctx, *pPut, *pPath)
 		}
-		if err = transfer.Start(); err != nil {
+		cancel()
+		if err != nil {
 			log.Fatalln(err)
 		}
-		transfer.PrettyPrint()
+		rc.PrettyPrint(os.Stdout, os.Stderr, (*pNoHeader&NoHeader) > 0, (*pNoHeader&NoServer) > 0)
+		// any failed host fails the transfer, as for commands
+		if failed(rc, *pFailOn) {
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 	// command
@@ -185,7 +446,7 @@ func main() {
 		}
 		cmd = string(script)
 	}
-	if cmd == "" {
+	if cmd == "" && *pRunScript == "" && *pSteps == "" && plan == nil {
 		log.Fatal("Command cannot be empty")
 	}
 	toReplaceCount := strings.Count(cmd, REPLACEMENT)
@@ -209,16 +470,343 @@ func main() {
 	// run
 	//cmd := "/bin/cat /data/tmp/phalcon-cli.log"
 	rc := common.NewRemoteCommand(hosts, cmd)
-	if err := rc.Start(); err != nil {
+	if plan != nil {
+		if rc, err = common.NewRemoteCommandPlan(plan); err != nil {
+			log.Fatalln(err)
+		}
+		// hosts left by -exclude
+		rc.Hosts = hosts
+	}
+	rc.RetryAttempts = *pRetry
+	rc.CommandTimeout = *pTimeout
+	rc.RunTimeout = *pRunTimeout
+	rc.MaxFailures = *pMaxFail
+	rc.FailFast = *pFailFast
+	rc.Serial = *pSerial
+	rc.SerialDelay = *pSerialDelay
+	rc.BatchSize = *pBatch
+	rc.BatchDelay = *pBatchDelay
+	if *pPause {
+		rc.BatchPause = func(next []string) bool {
+			ok, err := common.Ask(fmt.Sprintf("Continue with %d hosts %s?", len(next), strings.Join(next, ",")))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return ok
+		}
+		rc.SerialPause = func(next string) bool {
+			ok, err := common.Ask("Continue with " + next + "?")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return ok
+		}
+	}
+	rc.RequestPty = *pTTY
+	rc.Template = *pTemplate
+	rc.WorkDir = *pWorkDir
+	rc.DryRun = *pDryRun
+	rc.StripANSI = *pStripANSI
+	rc.MergeStderr = *pMerge
+	rc.Base64 = *pBase64
+	rc.MaxOutputBytes = *pMaxOutput
+	rc.ForwardAgent = *pForwardAgent
+	rc.ForwardX11 = *pForwardX11
+	rc.KubeContainer = *pContainer
+	rc.ShowBanner = *pBanner
+	rc.ErrorsOnly = *pErrorsOnly
+	rc.FailedOnly = *pFailedOnly
+	rc.MarkEmpty = *pMarkEmpty
+	rc.SortHosts = *pSort
+	rc.HeadLines, rc.TailLines = *pHead, *pTail
+	redact := common.C.Redact
+	if *pRedact != "" {
+		redact = append(redact, *pRedact)
+	}
+	if rc.Redact, err = common.RedactPatterns(redact, common.C.RedactStrings); err != nil {
+		log.Fatalln(err)
+	}
+	if *pGrep != "" {
+		if rc.OutputFilter, err = regexp.Compile(*pGrep); err != nil {
+			log.Fatalln("bad -grep:", err)
+		}
+		rc.FilterInvert = *pGrepInvert
+		rc.FilterCount = *pGrepCount
+	}
+	rc.DiffersOnly = *pDiffersOnly
+	if *pSudo {
+		rc.Sudo = true
+		// a password asked on terminal at login is used too
+		if rc.SudoPassword, err = common.AuthPassword(&common.C.Auth); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	// Ctrl-C stops remote commands
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		rc.Close()
+		cancel()
+	}()
+	switch *pColor {
+	case "always":
+		rc.Color = true
+	case "auto":
+		rc.Color = common.ColorEnabled(wo) && common.ColorEnabled(os.Stderr)
+	}
+	if *pLive {
+		rc.Stream = rc.PrefixStream(wo, os.Stderr)
+	}
+	var progress *common.Progress
+	if *pProgress {
+		if progress = common.NewProgress(os.Stderr, len(rc.Hosts)); progress != nil {
+			rc.OnHostDone = progress.Done
+		}
+	}
+	start := func(rc *common.RemoteCommand) (err error) {
+		if *pPods != "" {
+			_, err = rc.RunPodsContext(ctx)
+		} else if *pRunScript != "" {
+			_, err = rc.RunScriptContext(ctx, *pRunScript, flag.Args()...)
+		} else if *pSteps != "" {
+			var cmds []string
+			if cmds, err = readCommands(*pSteps); err == nil {
+				rc.ContinueOnError = *pContinue
+				_, err = rc.RunCommandsContext(ctx, cmds)
+			}
+		} else {
+			_, err = rc.StartContext(ctx)
+		}
+		return err
+	}
+	if n := len(common.UniqueHosts(rc.Hosts)); !*pYes && !rc.DryRun &&
+		(*pConfirm || common.C.Server.ConfirmHosts > 0 && n > common.C.Server.ConfirmHosts) {
+		what := strconv.Quote(cmd)
+		if *pRunScript != "" {
+			what = "script " + *pRunScript
+		} else if *pSteps != "" {
+			what = "steps of " + *pSteps
+		} else if plan != nil {
+			what = "plan " + *pPlan
+		}
+		ok, err := common.Confirm(what, n)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "aborted")
+			os.Exit(1)
+		}
+	}
+	canaryFailed := false
+	if *pCanary != "" {
+		var n int
+		if n, err = common.CanarySize(*pCanary, len(common.UniqueHosts(rc.Hosts))); err != nil {
+			log.Fatalln(err)
+		}
+		// one progress line counts canary and remaining hosts
+		notice := func(format string, a ...interface{}) {
+			if progress != nil {
+				progress.Printf(format, a...)
+			} else {
+				fmt.Fprintf(os.Stderr, format, a...)
+			}
+		}
+		canary, rest := rc.SplitCanary(n)
+		err = start(canary)
+		if failed := canary.ErrorHosts(); err != nil || len(failed) > 0 && rest != nil {
+			if err == nil {
+				// recorded and printed like a full run, remaining hosts are left out
+				notice("canary failed on %s, %d remaining hosts not run\n", strings.Join(failed, ","), len(rest.Hosts))
+				canaryFailed = true
+			}
+			rest = nil
+		} else if rest != nil {
+			notice("canary passed on %d hosts, running %d remaining hosts\n", len(canary.Hosts), len(rest.Hosts))
+			err = start(rest)
+		}
+		rc = common.JoinCanary(canary, rest)
+	} else {
+		err = start(rc)
+	}
+	if progress != nil {
+		progress.Finish()
+	}
+	if err != nil {
 		log.Fatalln(err)
 	}
+	// registered first to run after other defers
+	defer func() {
+		if canaryFailed || failed(rc, *pFailOn) {
+			os.Exit(1)
+		}
+	}()
+	defer rc.PrintAborted(os.Stderr)
+	if *pTiming > 0 {
+		defer rc.PrintTiming(os.Stderr, *pTiming)
+	}
+	if common.C.History != "" {
+		if err := rc.SaveHistory(common.C.History); err != nil {
+			log.Println("save history:", err)
+		}
+	}
+	if *pWebhook != "" {
+		common.C.Webhook.URL = *pWebhook
+	}
+	if common.C.Webhook.URL != "" {
+		// after output is printed, a slow endpoint does not hold it back
+		defer func() {
+			if err := rc.Notify(context.Background(), common.C.Webhook); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	if *pSummary {
+		defer fmt.Fprintln(os.Stderr, rc.Summary())
+	}
+	if *pOutputDir != "" {
+		if err := rc.WriteOutputDir(*pOutputDir, false); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if *pJSON {
+		if err := rc.JSONPrint(wo); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if *pCSV || *pTSV {
+		comma := ','
+		if *pTSV {
+			comma = '\t'
+		}
+		if err := rc.CSVPrint(wo, comma, *pValueOnly); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if *pYAML {
+		if err := rc.YAMLPrint(wo); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if *pDiff != "" {
+		if err := rc.PrettyPrintDiff(wo, *pDiff); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if *pAggregate {
+		rc.PrintAggregate(wo, os.Stderr)
+		return
+	}
+	if *pHistogram || *pHistHosts {
+		rc.PrintValueCounts(wo, os.Stderr, *pHistHosts)
+		return
+	}
+	if *pGrouped {
+		rc.PrettyPrintGrouped(wo, os.Stderr, (*pNoHeader&NoHeader) > 0)
+		return
+	}
 	rc.PrettyPrint(wo, os.Stderr, (*pNoHeader&NoHeader) > 0, (*pNoHeader&NoServer) > 0)
 }
 
+// configuredHosts hosts of all groups in config, by group name order
+func configuredHosts() []string {
+	groups := make([]string, 0, len(common.C.Server.Hosts))
+	for g := range common.C.Server.Hosts {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	var hosts []string
+	for _, g := range groups {
+		hosts = append(hosts, common.C.Server.Hosts[g]...)
+	}
+	return common.UniqueHosts(hosts)
+}
+
+// readCommands read command list file
+func readCommands(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return common.ReadCommands(f)
+}
+
+// failed whether run counts as failure by failon mode
+func failed(rc *common.RemoteCommand, failOn string) bool {
+	if failOn == "none" {
+		return false
+	}
+	for _, r := range rc.Result() {
+		if r.Error == "" {
+			continue
+		}
+		if failOn == "any" || r.ExitCode <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func printSample() {
 	fmt.Print(`server:
   default_group: vm
   default_port: 22
+  # max hosts executing at once, 0=unlimited
+  #max_parallel: 50
+  # ask before running on more hosts than this, skipped by -yes
+  #confirm_hosts: 100
+  # tcp connect and ssh handshake timeout
+  #connect_timeout: 10s
+  # max new connections per second, keeps bastions and fail2ban calm
+  #dial_rate: 20
+  # ping connections to fail hosts behind dropped connections instead of hanging
+  #keepalive: 30s
+  #keepalive_max: 3
+  # resolve host aliases with HostName/Port/User/IdentityFile of ssh config
+  #ssh_config: ~/.ssh/config
+  # read host groups from inventory file instead of hosts
+  #inventory: {/path/to/inventory}
+  # discover hosts of -ec2 tags, credentials from aws env/config files
+  #ec2:
+  #  region: us-east-1
+  #  public_ip: false
+  #  cache_ttl: 1m
+  # discover passing instances of -consul service, defaults from CONSUL_HTTP_ADDR/CONSUL_HTTP_TOKEN
+  #consul:
+  #  address: http://127.0.0.1:8500
+  #  token: ""
+  #  datacenter: ""
+  # reach hosts through a bastion
+  #jump_host: 10.0.0.1:22
+  # bastion auth, same as auth if not set
+  #jump_auth:
+  #  user: jump
+  #  private_key: {/path/to/bastion/key.pem}
+  # dial hosts and bastion through socks5 proxy
+  #socks5: 127.0.0.1:1080
+  #socks5_user: ""
+  #socks5_password: ""
+  # handshake algorithms, secure defaults if not set. legacy hosts may need e.g. aes128-cbc
+  #ciphers: [aes128-gcm@openssh.com, chacha20-poly1305@openssh.com, aes256-ctr]
+  #kex: [curve25519-sha256, ecdh-sha2-nistp256]
+  #macs: [hmac-sha2-256-etm@openssh.com, hmac-sha2-256]
+  # terminal type and LANG of pty sessions, LANG needs AcceptEnv LANG at sshd
+  #term_type: xterm-256color
+  #locale: en_US.UTF-8
+  # verify host keys against known_hosts
+  #strict_host_key: true
+  #known_hosts: {/path/to/known_hosts} # default ~/.ssh/known_hosts
+  # trust and save unknown host keys on first connect
+  #host_key_tofu: false
   hosts:
 	vm:
 	  - 172.16.80.129
@@ -227,17 +815,41 @@ func printSample() {
 auth:
   user: root
   password: {my password}
+  # plain password read from file if password is empty, else OPTOOL_PASSWORD or asked on terminal
+  #password_file: ~/.optool_password
   # higher priority than password
   private_key: {/path/to/my/private/key.pem}
   # not used
   #private_key_content: ""
+  # empty: read OPTOOL_KEY_PASSPHRASE or prompt if key is encrypted
   private_key_phrase: ""
+  # more keys tried in order, unparsable keys are skipped
+  #keys: [~/.ssh/id_ed25519, ~/.ssh/id_rsa_old]
+  # answers of keyboard-interactive prompts (OTP), asked on terminal if absent
+  #answers: []
   plain_password: true
 tags:
   ps: "/bin/ps"
   netstat: "/bin/netstat -lntpu"
   err: "/bin/grep ERROR /var/log/nginx/error.log_REPLACE_"
 # transfer_max_size: 1099511627776 #100MB
+# gzip level 1-9 used with -gz, default is gzip default level
+#gzip_level: 6
+# gzip at remote host, default gzip found in PATH
+#gzip_path: /bin/gzip
+# record runs to sqlite file, needs optool built with -tags sqlite
+#history: ~/.optool_history.db
+# kubectl of -pods, default kubectl found in PATH
+#kubectl: /usr/local/bin/kubectl
+# mask secrets in output as ***, regexps with groups mask only the groups
+#redact: ['(?i)password=(\S+)', 'AKIA[0-9A-Z]{16}']
+#redact_strings: [my-literal-token]
+# post json summary of each run: command, host counts, duration and failed hosts
+#webhook:
+#  url: https://chatops.example.com/optool
+#  timeout: 10s
+#  retries: 3
+#  headers: {Authorization: "Bearer xxx"}
 `)
 }
 